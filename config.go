@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/juju/loggo"
+
+	"github.com/ninjasphere/driver-go-zwave/devices/common"
+	"github.com/ninjasphere/driver-go-zwave/spi"
+)
+
+const (
+	defaultConfigDir      = "/usr/local/etc/openzwave"
+	defaultUserDataDir    = "."
+	defaultPollInterval   = 30 // seconds
+	defaultLogLevel       = "INFO"
+	defaultPairingTimeout = 60   // seconds
+	defaultLowBattery     = 20   // percent
+	defaultOfflineTimeout = 3600 // seconds
+
+	namesFile     = "zwave-names.json"
+	batteriesFile = "zwave-batteries.json"
+	libraryDir    = "library"
+)
+
+//
+// Zconfig is the persistent configuration of the driver. It is supplied
+// to Start and saved by sending it back with a "config" event.
+//
+type Zconfig struct {
+	ControllerDevice string                          `json:"controllerDevice,omitempty"` // serial device of the controller, empty to use the default
+	ConfigDir        string                          `json:"configDir"`                  // OpenZWave device configuration directory
+	UserDataDir      string                          `json:"userDataDir"`                // OpenZWave network cache and driver state directory
+	LibraryDir       string                          `json:"libraryDir"`                 // directory of declarative device mappings, defaults to userDataDir/library
+	PollInterval     int                             `json:"pollInterval"`               // seconds in which every polled value is polled once
+	NetworkKey       string                          `json:"networkKey,omitempty"`       // 16 byte key for secure devices, as 32 hex digits
+	LogLevel         string                          `json:"logLevel"`                   // one of TRACE, DEBUG, INFO, WARNING, ERROR, CRITICAL
+	PairingTimeout   int                             `json:"pairingTimeout"`             // seconds after which inclusion or exclusion is cancelled
+	LowBattery       int                             `json:"lowBattery"`                 // battery level, in percent, at or below which a battery is low
+	OfflineTimeout   int                             `json:"offlineTimeout"`             // seconds after which a node that has not been heard from is offline, if OpenZWave does not report dead nodes
+	HealSchedule     string                          `json:"healSchedule,omitempty"`     // local time of day, as HH:MM, at which the network is healed each night, empty to disable
+	HealReturnRoutes bool                            `json:"healReturnRoutes,omitempty"` // if true, heals also assign new return routes
+	Devices          map[string]*spi.DeviceConfig    `json:"devices,omitempty"`          // per device overrides, by natural id
+	Reporting        map[string]*spi.ReportingPolicy `json:"reporting,omitempty"`        // reporting policies for all devices, by channel id
+}
+
+func defaultConfig() *Zconfig {
+	return &Zconfig{
+		ConfigDir:      defaultConfigDir,
+		UserDataDir:    defaultUserDataDir,
+		PollInterval:   defaultPollInterval,
+		LogLevel:       defaultLogLevel,
+		PairingTimeout: defaultPairingTimeout,
+		LowBattery:     defaultLowBattery,
+		OfflineTimeout: defaultOfflineTimeout,
+		Devices:        make(map[string]*spi.DeviceConfig),
+	}
+}
+
+//
+// Answer a copy of the configuration that shares no maps with it. The
+// device configurations themselves are shared, since they are replaced,
+// never modified, once the driver has started.
+//
+func (config *Zconfig) snapshot() *Zconfig {
+	snapshot := *config
+	snapshot.Devices = make(map[string]*spi.DeviceConfig, len(config.Devices))
+	for naturalID, device := range config.Devices {
+		snapshot.Devices[naturalID] = device
+	}
+	snapshot.Reporting = make(map[string]*spi.ReportingPolicy, len(config.Reporting))
+	for channel, policy := range config.Reporting {
+		snapshot.Reporting[channel] = policy
+	}
+	return &snapshot
+}
+
+//
+// Fill unspecified fields of a configuration received from ninja with
+// their default values.
+//
+func (config *Zconfig) applyDefaults() {
+	defaults := defaultConfig()
+	if config.ConfigDir == "" {
+		config.ConfigDir = defaults.ConfigDir
+	}
+	if config.UserDataDir == "" {
+		config.UserDataDir = defaults.UserDataDir
+	}
+	if config.LibraryDir == "" {
+		config.LibraryDir = filepath.Join(config.UserDataDir, libraryDir)
+	}
+	if config.PollInterval == 0 {
+		config.PollInterval = defaults.PollInterval
+	}
+	if config.LogLevel == "" {
+		config.LogLevel = defaults.LogLevel
+	}
+	if config.PairingTimeout == 0 {
+		config.PairingTimeout = defaults.PairingTimeout
+	}
+	if config.LowBattery == 0 {
+		config.LowBattery = defaults.LowBattery
+	}
+	if config.OfflineTimeout == 0 {
+		config.OfflineTimeout = defaults.OfflineTimeout
+	}
+	if config.Devices == nil {
+		config.Devices = defaults.Devices
+	}
+}
+
+func (config *Zconfig) validate() error {
+	if config.ControllerDevice != "" {
+		if _, err := os.Stat(config.ControllerDevice); err != nil {
+			return fmt.Errorf("Invalid controllerDevice %s: %s", config.ControllerDevice, err)
+		}
+	}
+
+	for _, dir := range []string{config.ConfigDir, config.UserDataDir} {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("Invalid directory %s: %s", dir, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("Invalid directory %s: not a directory", dir)
+		}
+	}
+
+	if config.PollInterval < 0 {
+		return fmt.Errorf("Invalid pollInterval %d: must not be negative", config.PollInterval)
+	}
+
+	if config.LowBattery < 0 || config.LowBattery > 100 {
+		return fmt.Errorf("Invalid lowBattery %d: expected 0 to 100", config.LowBattery)
+	}
+
+	if config.HealSchedule != "" {
+		if _, _, ok := config.healTime(); !ok {
+			return fmt.Errorf("Invalid healSchedule %s: expected HH:MM", config.HealSchedule)
+		}
+	}
+
+	if config.OfflineTimeout < 0 {
+		return fmt.Errorf("Invalid offlineTimeout %d: must not be negative", config.OfflineTimeout)
+	}
+
+	if config.PairingTimeout < 0 {
+		return fmt.Errorf("Invalid pairingTimeout %d: must not be negative", config.PairingTimeout)
+	}
+
+	if config.NetworkKey != "" {
+		if _, err := config.networkKeyBytes(); err != nil {
+			return err
+		}
+	}
+
+	for channel, policy := range config.Reporting {
+		if err := policy.Validate(); err != nil {
+			return fmt.Errorf("Invalid reporting policy for %s channels: %s", channel, err)
+		}
+	}
+
+	for naturalID, device := range config.Devices {
+		if device == nil {
+			continue
+		}
+		if device.LowBattery < 0 || device.LowBattery > 100 {
+			return fmt.Errorf("Invalid lowBattery %d for %s: expected 0 to 100", device.LowBattery, naturalID)
+		}
+		if device.SensorType != "" && !common.IsSensorType(device.SensorType) {
+			return fmt.Errorf("Invalid sensorType %s for %s: expected one of contact, motion, water, smoke or co", device.SensorType, naturalID)
+		}
+		if device.MotionTimeout < 0 {
+			return fmt.Errorf("Invalid motionTimeout %d for %s: must not be negative", device.MotionTimeout, naturalID)
+		}
+		for channel, policy := range device.Reporting {
+			if err := policy.Validate(); err != nil {
+				return fmt.Errorf("Invalid reporting policy for the %s channel of %s: %s", channel, naturalID, err)
+			}
+		}
+	}
+
+	if _, ok := loggo.ParseLevel(config.LogLevel); !ok {
+		return fmt.Errorf("Invalid logLevel %s: expected one of TRACE, DEBUG, INFO, WARNING, ERROR or CRITICAL", config.LogLevel)
+	}
+
+	return nil
+}
+
+func (config *Zconfig) networkKeyBytes() ([]byte, error) {
+	key, err := hex.DecodeString(config.NetworkKey)
+	if err != nil || len(key) != 16 {
+		return nil, fmt.Errorf("Invalid networkKey: expected 32 hexadecimal digits")
+	}
+	return key, nil
+}
+
+//
+// Answer the OpenZWave command line options that reflect the configuration.
+//
+func (config *Zconfig) options() string {
+	options := []string{
+		fmt.Sprintf("--PollInterval %d", time.Duration(config.PollInterval)*time.Second/time.Millisecond),
+	}
+
+	if config.NetworkKey != "" {
+		key, _ := config.networkKeyBytes()
+		bytes := make([]string, len(key))
+		for i, b := range key {
+			bytes[i] = fmt.Sprintf("0x%02X", b)
+		}
+		options = append(options, fmt.Sprintf("--NetworkKey %s", strings.Join(bytes, ",")))
+	}
+
+	return strings.Join(options, " ")
+}
+
+func (config *Zconfig) logLevel() loggo.Level {
+	level, _ := loggo.ParseLevel(config.LogLevel)
+	return level
+}
+
+func (config *Zconfig) namesPath() string {
+	return filepath.Join(config.UserDataDir, namesFile)
+}
+
+//
+// Answer the hour and minute of the nightly heal, or false if no heal is
+// scheduled.
+//
+func (config *Zconfig) healTime() (int, int, bool) {
+	if config.HealSchedule == "" {
+		return 0, 0, false
+	}
+	at, err := time.Parse("15:04", config.HealSchedule)
+	if err != nil {
+		return 0, 0, false
+	}
+	return at.Hour(), at.Minute(), true
+}
+
+func (config *Zconfig) batteriesPath() string {
+	return filepath.Join(config.UserDataDir, batteriesFile)
+}
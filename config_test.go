@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/ninjasphere/driver-go-zwave/spi"
+)
+
+func validConfig() *Zconfig {
+	config := defaultConfig()
+	config.ConfigDir = os.TempDir()
+	config.UserDataDir = os.TempDir()
+	config.applyDefaults()
+	return config
+}
+
+func TestSensorTypeIsValidated(t *testing.T) {
+	for sensorType, valid := range map[string]bool{
+		"":        true,
+		"contact": true,
+		"motion":  true,
+		"water":   true,
+		"smoke":   true,
+		"co":      true,
+		"alarm":   false,
+		"door":    false,
+	} {
+		config := validConfig()
+		config.Devices["abc"] = &spi.DeviceConfig{SensorType: sensorType}
+		err := config.validate()
+		if valid && err != nil {
+			t.Errorf("expected sensorType %q to be valid: %s", sensorType, err)
+		} else if !valid && err == nil {
+			t.Errorf("expected sensorType %q to be invalid", sensorType)
+		}
+	}
+}
+
+func TestReportingModeIsValidated(t *testing.T) {
+	for mode, valid := range map[string]bool{
+		"throttle": true,
+		"debounce": true,
+		"":         false,
+		"deadband": false,
+	} {
+		mode := mode
+		config := validConfig()
+		config.Reporting = map[string]*spi.ReportingPolicy{"power": {Mode: &mode}}
+		err := config.validate()
+		if valid && err != nil {
+			t.Errorf("expected mode %q to be valid: %s", mode, err)
+		} else if !valid && err == nil {
+			t.Errorf("expected mode %q to be invalid", mode)
+		}
+	}
+}
+
+func TestReportingPolicyIsParsed(t *testing.T) {
+	policy := &spi.ReportingPolicy{}
+	err := json.Unmarshal([]byte(`{ "minInterval": 60, "repeatAfter": 900, "threshold": 0.2, "heartbeat": 1800 }`), policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.RepeatAfter == nil || *policy.RepeatAfter != 900 {
+		t.Errorf("expected repeatAfter 900, got %v", policy.RepeatAfter)
+	}
+	if policy.Heartbeat == nil || *policy.Heartbeat != 1800 {
+		t.Errorf("expected heartbeat 1800, got %v", policy.Heartbeat)
+	}
+}
+
+//
+// Devices update their configuration concurrently, while earlier updates
+// are being saved.
+//
+func TestConcurrentDeviceConfigUpdates(t *testing.T) {
+	td := newTestDriver()
+	td.SetEventHandler(func(event string, payload interface{}) error {
+		_, err := json.Marshal(payload)
+		return err
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			td.UpdateDeviceConfig("abc", func(config *spi.DeviceConfig) {
+				config.Parameters[strconv.Itoa(i)] = i
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	if parameters := td.DeviceConfig("abc").Parameters; len(parameters) != 50 {
+		t.Errorf("expected every update to be kept, got %v", parameters)
+	}
+}
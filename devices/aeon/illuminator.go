@@ -14,11 +14,15 @@ import (
 )
 
 const (
-	maxDeviceBrightness = 100             // by experiment, a level of 100 does not work for this device
-	maxDelay            = time.Second * 5 // maximum delay for apply calls
+	maxDeviceBrightness = 100 // by experiment, a level of 100 does not work for this device
+
+	powerTolerance  = 0.5 // W, changes smaller than this are not reported as changes
+	energyTolerance = 1.0 // Wh
 )
 
 var (
+	maxDelay = time.Second * 5 // maximum delay for apply calls
+
 	level_switch = openzwave.ValueID{CC.SWITCH_MULTILEVEL, 1, 0}
 	energy_meter = openzwave.ValueID{CC.METER, 1, 0}
 	power_meter  = openzwave.ValueID{CC.METER, 1, 8}
@@ -36,9 +40,9 @@ type illuminator struct {
 	// It is updated from the device on a confirmed attempt to adjust the level to a non-zero value
 	brightness uint8
 
-	refresh chan struct{} // used to wait for confirmation of updates after a level change
-
-	emitter utils.Emitter
+	emitter       utils.Emitter
+	powerEmitter  utils.Emitter
+	energyEmitter utils.Emitter
 }
 
 func IlluminatorFactory(driver spi.Driver, node openzwave.Node) openzwave.Device {
@@ -48,7 +52,7 @@ func IlluminatorFactory(driver spi.Driver, node openzwave.Node) openzwave.Device
 
 	var ok bool
 
-	device.brightness, ok = device.Node.GetValueWithId(level_switch).GetUint8()
+	device.brightness, ok = device.Node().GetValueWithId(level_switch).GetUint8()
 	if !ok || device.brightness == 0 {
 		// we have to reset brightness to 100 since we apply brightness when
 		// we switch it on
@@ -64,13 +68,22 @@ func IlluminatorFactory(driver spi.Driver, node openzwave.Node) openzwave.Device
 
 	(*device.Info.Signatures)["ninja:thingType"] = "light"
 
-	device.refresh = make(chan struct{}, 0)
-
-	device.emitter = utils.Filter(
+	// the brightness channel is reported with the on-off channel, so
+	// shares its policy
+	device.emitter = device.Reporter("on-off", spi.DefaultPolicy,
 		func(level utils.Equatable) {
 			device.unconditionalSendLightState(level.(*utils.WrappedUint8).Unwrap())
-		},
-		30*time.Second)
+		})
+
+	device.powerEmitter = device.Reporter("power", spi.DefaultPolicy,
+		func(reading utils.Equatable) {
+			device.powerChannel.SendState(reading.(*utils.WrappedFloat).Unwrap())
+		})
+
+	device.energyEmitter = device.Reporter("energy", spi.DefaultPolicy,
+		func(reading utils.Equatable) {
+			device.energyChannel.SendState(reading.(*utils.WrappedFloat).Unwrap())
+		})
 
 	return device
 }
@@ -79,18 +92,23 @@ func IlluminatorFactory(driver spi.Driver, node openzwave.Node) openzwave.Device
 
 func (device *illuminator) NodeAdded() {
 
-	node := device.Node
+	node := device.Node()
 	api := device.Driver.ZWave()
 	conn := device.Driver.Connection()
 
-	err := conn.ExportDevice(device)
-	if err != nil {
-		api.Logger().Infof("failed to export node: %v as device: %s", node, err)
+	if device.IsExported() {
+		// the node has been re-added, the channels exported for the
+		// previous incarnation of the node are reused.
+		device.startPolling()
+		return
+	}
+
+	if !device.Export(device) {
 		return
 	}
 
 	device.onOffChannel = channels.NewOnOffChannel(device)
-	err = conn.ExportChannel(device, device.onOffChannel, "on-off")
+	err := conn.ExportChannel(device, device.onOffChannel, "on-off")
 	if err != nil {
 		api.Logger().Infof("failed to export on-off channel for %v: %s", node, err)
 		return
@@ -114,36 +132,36 @@ func (device *illuminator) NodeAdded() {
 		api.Logger().Infof("failed to export energy channel for %v: %s", node, err)
 	}
 
-	device.Node.GetValueWithId(level_switch).SetPollingState(true)
-	device.Node.GetValueWithId(power_meter).SetPollingState(true)
-	device.Node.GetValueWithId(energy_meter).SetPollingState(true)
+	device.startPolling()
+}
 
+func (device *illuminator) startPolling() {
+	device.Poll(level_switch, power_meter, energy_meter)
 }
 
 func (device *illuminator) NodeChanged() {
 }
 
 func (device *illuminator) NodeRemoved() {
+	device.Detach()
 }
 
 func (device *illuminator) ValueChanged(v openzwave.Value) {
 	switch v.Id() {
 	case level_switch:
-		select {
-		case device.refresh <- struct{}{}:
-		default:
+		if !device.Refreshed() {
 			device.sendLightState()
 		}
 	case power_meter:
 		readingW, ok := v.GetFloat()
-		if ok {
-			device.powerChannel.SendState(readingW)
+		if ok && device.powerChannel != nil {
+			device.powerEmitter.Emit(utils.WrapFloatWithTolerance(readingW, powerTolerance))
 		}
 	case energy_meter:
 		readingKWH, ok := v.GetFloat()
-		if ok {
+		if ok && device.energyChannel != nil {
 			watts := readingKWH * 1000
-			device.energyChannel.SendState(watts)
+			device.energyEmitter.Emit(utils.WrapFloatWithTolerance(watts, energyTolerance))
 		}
 	}
 }
@@ -151,6 +169,9 @@ func (device *illuminator) ValueChanged(v openzwave.Value) {
 // Ninja protocols
 
 func (device *illuminator) SetOnOff(state bool) error {
+	if err := device.CheckPresent(); err != nil {
+		return err
+	}
 	level := uint8(0)
 	if state {
 		level = device.brightness
@@ -159,7 +180,10 @@ func (device *illuminator) SetOnOff(state bool) error {
 }
 
 func (device *illuminator) ToggleOnOff() error {
-	level, ok := device.Node.GetValueWithId(level_switch).GetUint8()
+	if err := device.CheckPresent(); err != nil {
+		return err
+	}
+	level, ok := device.Node().GetValueWithId(level_switch).GetUint8()
 	if !ok {
 		return fmt.Errorf("Unable to determine current state of switch")
 	}
@@ -171,6 +195,9 @@ func (device *illuminator) ToggleOnOff() error {
 }
 
 func (device *illuminator) SetBrightness(state float64) error {
+	if err := device.CheckPresent(); err != nil {
+		return err
+	}
 
 	var err error = nil
 	if state < 0 {
@@ -178,7 +205,7 @@ func (device *illuminator) SetBrightness(state float64) error {
 	} else if state > 1.0 {
 		state = 1.0
 	}
-	level, ok := device.Node.GetValueWithId(level_switch).GetUint8()
+	level, ok := device.Node().GetValueWithId(level_switch).GetUint8()
 	if ok {
 		newLevel := uint8(state * maxDeviceBrightness)
 		if level > 0 {
@@ -195,12 +222,11 @@ func (device *illuminator) SetBrightness(state float64) error {
 
 //
 // Issue a set against the OpenZWave API, then wait until the refreshed
-// value matches the requested level or until a timeout, issuing refreshes
-// as required.
+// value matches the requested level or until a timeout.
 //
 func (device *illuminator) setDeviceLevel(level uint8) error {
 
-	val := device.Node.GetValueWithId(level_switch)
+	val := device.Node().GetValueWithId(level_switch)
 
 	if level >= maxDeviceBrightness {
 		// aeon will reject attempts to set the level to exactly 100
@@ -210,41 +236,27 @@ func (device *illuminator) setDeviceLevel(level uint8) error {
 	if !val.SetUint8(level) {
 		return fmt.Errorf("Failed to set level to %d - set failed", level)
 	}
-	timer := time.NewTimer(maxDelay)
-
-	// loop until timeout or until refresh yields expected level
 
-	for {
-		if !val.Refresh() {
-			return fmt.Errorf("Failed to set required level to %d - refresh failed", level)
-		}
-		select {
-		case timeout := <-timer.C:
-			_ = timeout
-			if level != 0 {
-				device.brightness = level
-			}
-			return fmt.Errorf("Failed to set required level to %d - timeout", level)
-		case refreshed := <-device.refresh:
-			_ = refreshed
-			current, ok := val.GetUint8()
-			if ok && current == level {
-				if level != 0 {
-					device.brightness = level
-				}
-				device.emitter.Reset()
-				return nil
-			}
-		}
+	err := device.Confirm(val, maxDelay, func(val openzwave.Value) bool {
+		current, ok := val.GetUint8()
+		return ok && current == level
+	})
+	if level != 0 {
+		device.brightness = level
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to set required level to %d - %s", level, err)
 	}
+	device.emitter.Reset()
+	return nil
 }
 
 //
 // This call is used to reflect notifications about the current
-// state of the light back to towards the ninja network
+// state of the light back to the ninja network
 //
 func (device *illuminator) sendLightState() {
-	level, ok := device.Node.GetValueWithId(level_switch).GetUint8()
+	level, ok := device.Node().GetValueWithId(level_switch).GetUint8()
 	if ok {
 		//
 		// Emit the current state, but filter out levels that don't change
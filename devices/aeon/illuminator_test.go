@@ -0,0 +1,124 @@
+package aeon
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ninjasphere/go-openzwave"
+
+	"github.com/ninjasphere/driver-go-zwave/fake"
+)
+
+var (
+	illuminatorProduct     = openzwave.ProductId{"0086", "0062"}
+	illuminatorDescription = openzwave.ProductDescription{"Aeon Labs", "Micro Smart Dimmer", "Dimmer"}
+)
+
+//
+// Add an illuminator that is switched off to a fake network. Refreshes are
+// delayed, as they are by a real network, so that each refresh arrives
+// while setDeviceLevel is waiting for it.
+//
+func newIlluminator(t *testing.T) (*fake.Driver, *fake.Value, *illuminator) {
+	driver := fake.NewDriver(IlluminatorFactory)
+	node := driver.API.NewNode(2, illuminatorProduct, illuminatorDescription)
+	level := node.AddValue(level_switch, uint8(0)).SetRefreshDelay(10 * time.Millisecond)
+	node.AddValue(power_meter, 0.0)
+	node.AddValue(energy_meter, 0.0)
+
+	device := driver.API.Join(node).(*illuminator)
+	if device.onOffChannel == nil {
+		t.Fatal("expected the on-off channel to be exported")
+	}
+	return driver, level, device
+}
+
+func TestSetDeviceLevelConfirmedByRefresh(t *testing.T) {
+	driver, level, device := newIlluminator(t)
+
+	if err := device.setDeviceLevel(50); err != nil {
+		t.Fatal(err)
+	}
+
+	if sets := level.Sets(); len(sets) != 1 || sets[0] != uint8(50) {
+		t.Errorf("expected a single set to 50, got %v", sets)
+	}
+	if current, _ := level.GetUint8(); current != 50 {
+		t.Errorf("expected the device level to be 50, got %d", current)
+	}
+	if device.brightness != 50 {
+		t.Errorf("expected the brightness to be cached as 50, got %d", device.brightness)
+	}
+
+	device.sendLightState()
+	states := driver.Conn.States("on-off")
+	if len(states) == 0 || states[len(states)-1] != true {
+		t.Errorf("expected the light to be reported on, got %v", states)
+	}
+}
+
+func TestSetDeviceLevelTimesOutWhenIgnored(t *testing.T) {
+	defer func(delay time.Duration) { maxDelay = delay }(maxDelay)
+	maxDelay = 100 * time.Millisecond
+
+	_, level, device := newIlluminator(t)
+	level.SetBehavior(fake.Ignore)
+
+	err := device.setDeviceLevel(50)
+	if err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if level.Refreshes() == 0 {
+		t.Error("expected the level to be refreshed while waiting")
+	}
+	if health := device.Health(); health.FailedCommands != 1 || health.LastFailure == nil {
+		t.Errorf("expected the timeout to be counted as a failed command, got %+v", health)
+	}
+}
+
+func TestSetDeviceLevelFailsWhenRejected(t *testing.T) {
+	_, level, device := newIlluminator(t)
+	level.SetBehavior(fake.Reject)
+
+	err := device.setDeviceLevel(50)
+	if err == nil || !strings.Contains(err.Error(), "set failed") {
+		t.Fatalf("expected the set to fail, got %v", err)
+	}
+	if level.Refreshes() != 0 {
+		t.Error("expected no refresh after a rejected set")
+	}
+	if health := device.Health(); health.FailedCommands != 0 {
+		t.Errorf("expected a rejected set not to be counted as a failed command, got %+v", health)
+	}
+}
+
+//
+// Replay captured RPC calls against the illuminator. The illuminator
+// reports the state it has confirmed when the level is next polled.
+//
+func TestReplayTurnOnThenBrightness(t *testing.T) {
+	driver, level, _ := newIlluminator(t)
+	poll := func() {
+		current, _ := level.GetUint8()
+		level.Emit(current)
+	}
+
+	if err := driver.Conn.Replay("../../testdata/turn-on.json"); err != nil {
+		t.Fatal(err)
+	}
+	poll()
+
+	if err := driver.Conn.Replay("../../testdata/brightness-0.5.json"); err != nil {
+		t.Fatal(err)
+	}
+	poll()
+
+	if states := driver.Conn.States("on-off"); !reflect.DeepEqual(states, []interface{}{true, true}) {
+		t.Errorf("expected on-off states [true true], got %v", states)
+	}
+	if states := driver.Conn.States("brightness"); !reflect.DeepEqual(states, []interface{}{1.0, 0.5}) {
+		t.Errorf("expected brightness states [1 0.5], got %v", states)
+	}
+}
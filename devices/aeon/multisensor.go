@@ -1,7 +1,7 @@
 package aeon
 
 import (
-	"time"
+	"fmt"
 
 	"github.com/ninjasphere/go-openzwave"
 	"github.com/ninjasphere/go-openzwave/CC"
@@ -18,16 +18,68 @@ var (
 	illuminance_sensor = openzwave.ValueID{CC.SENSOR_MULTILEVEL, 1, 3}
 	humidity_sensor    = openzwave.ValueID{CC.SENSOR_MULTILEVEL, 1, 5}
 	battery_sensor     = openzwave.ValueID{CC.BATTERY, 1, 0}
+
+	//
+	// The configuration parameters of the multisensor (DSB05) that can be
+	// changed from ninja. Parameter 3 is the motion timeout. Parameters 4
+	// and 5 are list parameters, which OpenZWave sets by the labels of
+	// their values.
+	//
+	motionEnabledOptions = map[int]string{0: "Disable", 1: "Enable"}
+	motionCommandOptions = map[int]string{1: "Basic Set", 2: "Sensor Binary Report"}
+
+	multisensorParameters = []*spi.Parameter{
+		{3, "motionTimeout", "seconds without motion after which the sensor reports that motion has cleared", 1, maxMotionTimeout, nil},
+		{4, "motionEnabled", "1 to enable the motion sensor, 0 to disable it", 0, 1, motionEnabledOptions},
+		{5, "motionCommand", "the command sent on motion: 1 for BASIC SET, 2 for SENSOR_BINARY REPORT", 1, 2, motionCommandOptions},
+		{101, "group1Reports", "the reports sent to group 1: the sum of 1 (battery), 32 (temperature), 64 (humidity) and 128 (illuminance)", 0, 225, nil},
+		{102, "group2Reports", "the reports sent to group 2, as for group1Reports", 0, 225, nil},
+		{103, "group3Reports", "the reports sent to group 3, as for group1Reports", 0, 225, nil},
+		{111, "group1Interval", "seconds between reports to group 1", 1, 2147483647, nil},
+		{112, "group2Interval", "seconds between reports to group 2", 1, 2147483647, nil},
+		{113, "group3Interval", "seconds between reports to group 3", 1, 2147483647, nil},
+	}
+)
+
+const (
+	temperatureTolerance = 0.05 // degrees, less than the resolution of the sensor
+	illuminanceTolerance = 0.5  // lux
+	humidityTolerance    = 0.5  // %
+
+	maxMotionTimeout = 32767 // seconds, the largest value of the 2 byte motion timeout parameter (3)
 )
 
 type multisensor struct {
 	spi.Device
-	motionChannel      *channels.MotionChannel
-	motionSensor       utils.Emitter
+	motionChannel      *motionChannel
+	motionSensor       *spi.MotionReporter
 	temperatureChannel *channels.TemperatureChannel
+	temperatureSensor  utils.Emitter
 	illuminanceChannel *channels.IlluminanceChannel
+	illuminanceSensor  utils.Emitter
 	humidityChannel    *channels.HumidityChannel
+	humiditySensor     utils.Emitter
 	batteryChannel     *channels.BatteryChannel
+	batterySensor      utils.Emitter
+	configChannel      *spi.ConfigurationChannel
+}
+
+//
+// The motion channel reports motion with a true "state" event and reports
+// that motion has cleared with a false "state" event.
+//
+type motionChannel struct {
+	*channels.MotionChannel
+	device *multisensor
+}
+
+//
+// Set the number of seconds without motion after which the sensor itself
+// reports that motion has cleared. The sensor applies the change when it
+// next wakes up.
+//
+func (channel *motionChannel) SetTimeout(seconds int) error {
+	return channel.device.SetMotionTimeout(seconds)
 }
 
 func MultiSensorFactory(driver spi.Driver, node openzwave.Node) openzwave.Device {
@@ -37,25 +89,51 @@ func MultiSensorFactory(driver spi.Driver, node openzwave.Node) openzwave.Device
 
 	(*device.Info.Signatures)["ninja:thingType"] = "sensor"
 
-	device.motionSensor = utils.Filter(func(next utils.Equatable) {
-		device.motionChannel.SendMotion()
-	}, 1*time.Second)
+	device.motionSensor = device.MotionReporter("motion", func(motion bool) {
+		if motion {
+			device.motionChannel.SendMotion()
+		} else {
+			device.motionChannel.SendEvent("state", false)
+		}
+	})
+
+	device.temperatureSensor = device.Reporter("temperature", spi.DefaultPolicy, func(next utils.Equatable) {
+		device.temperatureChannel.SendState(next.(*utils.WrappedFloat).Unwrap())
+	})
+
+	device.illuminanceSensor = device.Reporter("illuminance", spi.DefaultPolicy, func(next utils.Equatable) {
+		device.illuminanceChannel.SendState(next.(*utils.WrappedFloat).Unwrap())
+	})
+
+	device.humiditySensor = device.Reporter("humidity", spi.DefaultPolicy, func(next utils.Equatable) {
+		device.humidityChannel.SendState(next.(*utils.WrappedFloat).Unwrap())
+	})
+
+	device.batterySensor = device.Reporter("battery", spi.DefaultPolicy, func(next utils.Equatable) {
+		device.batteryChannel.SendState(next.(*utils.WrappedUint8).Float())
+	})
+
 	return device
 }
 
 func (device *multisensor) NodeAdded() {
-	node := device.Node
+	node := device.Node()
 	api := device.Driver.ZWave()
 	conn := device.Driver.Connection()
 
-	err := conn.ExportDevice(device)
-	if err != nil {
-		api.Logger().Infof("failed to export node: %v as device: %s", node, err)
+	if device.IsExported() {
+		// the node has been re-added, the channels exported for the
+		// previous incarnation of the node are reused.
+		device.startPolling()
 		return
 	}
 
-	device.motionChannel = channels.NewMotionChannel(device)
-	err = conn.ExportChannel(device, device.motionChannel, "motion")
+	if !device.Export(device) {
+		return
+	}
+
+	device.motionChannel = &motionChannel{channels.NewMotionChannel(device), device}
+	err := conn.ExportChannel(device, device.motionChannel, "motion")
 	if err != nil {
 		api.Logger().Infof("failed to export motion channel for %v: %s", node, err)
 		return
@@ -66,8 +144,6 @@ func (device *multisensor) NodeAdded() {
 	if err != nil {
 		api.Logger().Infof("failed to export illuminance channel for %v: %s", node, err)
 		return
-	} else {
-		node.GetValueWithId(illuminance_sensor).SetPollingState(true)
 	}
 
 	device.temperatureChannel = channels.NewTemperatureChannel(device)
@@ -75,8 +151,6 @@ func (device *multisensor) NodeAdded() {
 	if err != nil {
 		api.Logger().Infof("failed to export temperature channel for %v: %s", node, err)
 		return
-	} else {
-		node.GetValueWithId(temperature_sensor).SetPollingState(true)
 	}
 
 	device.humidityChannel = channels.NewHumidityChannel(device)
@@ -84,8 +158,6 @@ func (device *multisensor) NodeAdded() {
 	if err != nil {
 		api.Logger().Infof("failed to export humidity channel for %v: %s", node, err)
 		return
-	} else {
-		node.GetValueWithId(humidity_sensor).SetPollingState(true)
 	}
 
 	device.batteryChannel = channels.NewBatteryChannel(device)
@@ -93,44 +165,72 @@ func (device *multisensor) NodeAdded() {
 	if err != nil {
 		api.Logger().Infof("failed to export battery channel for %v: %s", node, err)
 		return
-	} else {
-		node.GetValueWithId(battery_sensor).SetPollingState(true)
 	}
+
+	device.configChannel = spi.NewConfigurationChannel(&device.Device, multisensorParameters)
+	err = conn.ExportChannel(device, device.configChannel, "configuration")
+	if err != nil {
+		api.Logger().Infof("failed to export configuration channel for %v: %s", node, err)
+		return
+	}
+
+	device.startPolling()
+}
+
+func (device *multisensor) startPolling() {
+	device.Poll(illuminance_sensor, temperature_sensor, humidity_sensor, battery_sensor)
+	device.ApplyParameters()
 }
 
 func (device *multisensor) NodeChanged() {
 }
 
 func (device *multisensor) NodeRemoved() {
-
+	device.Detach()
 }
 
 func (device *multisensor) ValueChanged(value openzwave.Value) {
+	if value.Id().CommandClassId == CC.CONFIGURATION {
+		if device.configChannel != nil {
+			device.configChannel.SendParameters()
+		}
+		return
+	}
+
 	switch value.Id() {
 	case motion_sensor: // motion
 		flag, ok := value.GetBool()
-		if ok && flag && device.motionChannel != nil {
-			device.motionSensor.Emit(utils.WrapBool(true))
+		if ok && device.motionChannel != nil {
+			device.motionSensor.Report(flag)
 		}
 	case temperature_sensor: // temperature
 		valF, ok := value.GetFloat()
 		if ok && device.temperatureChannel != nil {
-			device.temperatureChannel.SendState(valF)
+			device.temperatureSensor.Emit(utils.WrapFloatWithTolerance(valF, temperatureTolerance))
 		}
 	case illuminance_sensor: // luminance
 		valF, ok := value.GetFloat()
 		if ok && device.illuminanceChannel != nil {
-			device.illuminanceChannel.SendState(valF)
+			device.illuminanceSensor.Emit(utils.WrapFloatWithTolerance(valF, illuminanceTolerance))
 		}
 	case humidity_sensor: // relative humidity
 		valF, ok := value.GetFloat()
-		if ok {
-			device.humidityChannel.SendState(valF)
+		if ok && device.humidityChannel != nil {
+			device.humiditySensor.Emit(utils.WrapFloatWithTolerance(valF, humidityTolerance))
 		}
 	case battery_sensor: // battery
 		valB, ok := value.GetUint8()
-		if ok {
-			device.batteryChannel.SendState((float64)(valB))
+		if ok && device.batteryChannel != nil && device.TrackBattery(valB, device.batteryChannel.SendEvent) {
+			device.batterySensor.Emit(utils.WrapUint8(valB))
 		}
 	}
 }
+
+// Ninja protocols
+
+func (device *multisensor) SetMotionTimeout(seconds int) error {
+	if device.configChannel == nil {
+		return fmt.Errorf("Failed to set motion timeout - the configuration channel has not been exported")
+	}
+	return device.configChannel.Set(3, seconds)
+}
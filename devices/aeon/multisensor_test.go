@@ -0,0 +1,211 @@
+package aeon
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ninjasphere/go-openzwave"
+	"github.com/ninjasphere/go-openzwave/CC"
+
+	"github.com/ninjasphere/driver-go-zwave/fake"
+	"github.com/ninjasphere/driver-go-zwave/spi"
+)
+
+var (
+	multisensorProduct     = openzwave.ProductId{"0086", "0005"}
+	multisensorDescription = openzwave.ProductDescription{"Aeon Labs", "Multisensor", "Multisensor"}
+)
+
+func newMultisensor(t *testing.T) (*fake.Driver, *fake.Node, *multisensor) {
+	return newMultisensorWithValues(t, nil)
+}
+
+//
+// Answer a multisensor whose node also has the specified values, such as
+// its configuration parameters.
+//
+func newMultisensorWithValues(t *testing.T, values map[openzwave.ValueID]interface{}) (*fake.Driver, *fake.Node, *multisensor) {
+	driver := fake.NewDriver(MultiSensorFactory)
+	node := driver.API.NewNode(5, multisensorProduct, multisensorDescription)
+	node.AddValue(motion_sensor, false)
+	node.AddValue(temperature_sensor, 0.0)
+	node.AddValue(illuminance_sensor, 0.0)
+	node.AddValue(humidity_sensor, 0.0)
+	node.AddValue(battery_sensor, uint8(100))
+	for id, value := range values {
+		node.AddValue(id, value)
+	}
+
+	device := driver.API.Join(node).(*multisensor)
+	if device.motionChannel == nil || device.batteryChannel == nil {
+		t.Fatal("expected the multisensor's channels to be exported")
+	}
+	return driver, node, device
+}
+
+func TestMultisensorReportsMotion(t *testing.T) {
+	driver, node, _ := newMultisensor(t)
+	motion := node.GetValueWithId(motion_sensor).(*fake.Value)
+
+	motion.Emit(true)
+	motion.Emit(false)
+
+	expected := []interface{}{true, false}
+	if states := driver.Conn.States("motion"); !reflect.DeepEqual(states, expected) {
+		t.Errorf("expected motion states %v, got %v", expected, states)
+	}
+}
+
+func TestMultisensorReportsTemperature(t *testing.T) {
+	driver, node, _ := newMultisensor(t)
+	temperature := node.GetValueWithId(temperature_sensor).(*fake.Value)
+
+	temperature.Emit(21.5)
+	temperature.Emit(21.52) // within the tolerance of the sensor
+	temperature.Emit(23.0)
+
+	expected := []interface{}{21.5, 23.0}
+	if states := driver.Conn.States("temperature"); !reflect.DeepEqual(states, expected) {
+		t.Errorf("expected temperature states %v, got %v", expected, states)
+	}
+}
+
+func TestMultisensorReportsBattery(t *testing.T) {
+	driver, node, _ := newMultisensor(t)
+	battery := node.GetValueWithId(battery_sensor).(*fake.Value)
+
+	battery.Emit(uint8(80))
+	battery.Emit(uint8(10))
+
+	expected := []interface{}{80.0, 10.0}
+	if states := driver.Conn.States("battery"); !reflect.DeepEqual(states, expected) {
+		t.Errorf("expected battery states %v, got %v", expected, states)
+	}
+
+	low := false
+	for _, event := range driver.Conn.Events() {
+		if event.Channel == "battery" && event.Event == "low" {
+			low = true
+		}
+	}
+	if !low {
+		t.Error("expected a low battery event at 10%")
+	}
+}
+
+func parameter(index uint8) openzwave.ValueID {
+	return openzwave.ValueID{CC.CONFIGURATION, 1, index}
+}
+
+var wake_up_interval = openzwave.ValueID{CC.WAKE_UP, 1, 0}
+
+func TestMultisensorAppliesDesiredParameters(t *testing.T) {
+	driver, node, device := newMultisensorWithValues(t, map[openzwave.ValueID]interface{}{
+		parameter(3):   "240",
+		parameter(4):   "Enable",
+		parameter(5):   "Basic Set",
+		parameter(101): uint8(0),
+	})
+	driver.UpdateDeviceConfig(device.Info.NaturalID, func(config *spi.DeviceConfig) {
+		config.Parameters["3"] = 60
+		config.Parameters["4"] = 0
+		config.Parameters["5"] = 2
+		config.Parameters["101"] = 225
+	})
+
+	if pending := device.ApplyParameters(); !reflect.DeepEqual(pending, []uint8{3, 4, 5, 101}) {
+		t.Errorf("expected parameters 3, 4, 5 and 101 to be pending, got %v", pending)
+	}
+
+	for index, expected := range map[uint8][]interface{}{
+		3:   {"60"},
+		4:   {"Disable"},
+		5:   {"Sensor Binary Report"},
+		101: {uint8(225)},
+	} {
+		if sets := node.GetValueWithId(parameter(index)).(*fake.Value).Sets(); !reflect.DeepEqual(sets, expected) {
+			t.Errorf("expected parameter %d to be set to %v, got %v", index, expected, sets)
+		}
+	}
+
+	if pending := device.ApplyParameters(); len(pending) != 0 {
+		t.Errorf("expected no parameters to be pending once accepted, got %v", pending)
+	}
+}
+
+func TestMultisensorWritesUnacceptedParameterOnce(t *testing.T) {
+	driver, node, device := newMultisensorWithValues(t, map[openzwave.ValueID]interface{}{
+		parameter(3): "240",
+	})
+	value := node.GetValueWithId(parameter(3)).(*fake.Value).SetBehavior(fake.Ignore)
+	driver.UpdateDeviceConfig(device.Info.NaturalID, func(config *spi.DeviceConfig) {
+		config.Parameters["3"] = 60
+	})
+
+	device.ApplyParameters()
+	if pending := device.ApplyParameters(); !reflect.DeepEqual(pending, []uint8{3}) {
+		t.Errorf("expected parameter 3 to be pending until the sensor accepts it, got %v", pending)
+	}
+	if sets := value.Sets(); !reflect.DeepEqual(sets, []interface{}{"60"}) {
+		t.Errorf("expected parameter 3 to be written once until the node wakes up, got %v", sets)
+	}
+}
+
+func TestMultisensorReportsListParametersByValue(t *testing.T) {
+	_, _, device := newMultisensorWithValues(t, map[openzwave.ValueID]interface{}{
+		parameter(4): "Enable",
+		parameter(5): "Sensor Binary Report",
+	})
+
+	for index, expected := range map[uint8]int{4: 1, 5: 2} {
+		if value, ok := device.GetParameter(index); !ok || value != expected {
+			t.Errorf("expected parameter %d to be %d, got %d (%v)", index, expected, value, ok)
+		}
+	}
+	if err := device.configChannel.Set(4, 2); err == nil {
+		t.Error("expected a value that is not an option of parameter 4 to be rejected")
+	}
+}
+
+func TestMultisensorSetsWakeUpIntervalWhenAwake(t *testing.T) {
+	driver, node, device := newMultisensorWithValues(t, map[openzwave.ValueID]interface{}{
+		wake_up_interval: 3600.0,
+	})
+	value := node.GetValueWithId(wake_up_interval).(*fake.Value)
+
+	if err := device.configChannel.SetWakeUpInterval(600); err != nil {
+		t.Fatal(err)
+	}
+	if config := driver.DeviceConfig(device.Info.NaturalID); config.WakeUpInterval != 600 {
+		t.Errorf("expected the wake up interval to be saved, got %d", config.WakeUpInterval)
+	}
+	if sets := value.Sets(); len(sets) != 0 {
+		t.Errorf("expected the wake up interval to be queued until the sensor wakes up, got %v", sets)
+	}
+	if state := device.QueueState(); !reflect.DeepEqual(state.Pending, []string{"wake up interval"}) {
+		t.Errorf("expected the wake up interval to be queued, got %v", state.Pending)
+	}
+
+	device.WakeUp()
+	if sets := value.Sets(); !reflect.DeepEqual(sets, []interface{}{600.0}) {
+		t.Errorf("expected the wake up interval to be set once when the sensor wakes up, got %v", sets)
+	}
+	if interval, _ := device.WakeUpInterval(); interval != 600*time.Second {
+		t.Errorf("expected a wake up interval of 10 minutes, got %s", interval)
+	}
+}
+
+func TestMultisensorRejectsInvalidWakeUpInterval(t *testing.T) {
+	_, _, device := newMultisensorWithValues(t, map[openzwave.ValueID]interface{}{
+		wake_up_interval: 3600.0,
+	})
+	if err := device.SetWakeUpInterval(0); err == nil {
+		t.Error("expected a wake up interval of 0 to be rejected")
+	}
+
+	_, _, awake := newMultisensor(t)
+	if err := awake.SetWakeUpInterval(600); err == nil {
+		t.Error("expected a device that does not sleep to reject a wake up interval")
+	}
+}
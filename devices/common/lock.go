@@ -0,0 +1,208 @@
+package common
+
+import (
+	"fmt"
+
+	"github.com/ninjasphere/go-openzwave"
+	"github.com/ninjasphere/go-openzwave/CC"
+
+	"github.com/ninjasphere/go-ninja/channels"
+
+	"github.com/ninjasphere/driver-go-zwave/spi"
+	"github.com/ninjasphere/driver-go-zwave/utils"
+)
+
+var (
+	door_lock      = openzwave.ValueID{CC.DOOR_LOCK, 1, 0}
+	battery_sensor = openzwave.ValueID{CC.BATTERY, 1, 0}
+)
+
+type lock struct {
+	spi.Device
+
+	lockChannel    *lockChannel
+	lockEmitter    utils.Emitter
+	batteryChannel *channels.BatteryChannel
+	batteryEmitter utils.Emitter
+}
+
+//
+// The lock channel reports whether the lock is locked with "state" events.
+//
+type lockChannel struct {
+	*spi.StateChannel
+	device *lock
+}
+
+func (channel *lockChannel) Lock() error {
+	return channel.device.SetLocked(true)
+}
+
+func (channel *lockChannel) Unlock() error {
+	return channel.device.SetLocked(false)
+}
+
+func (channel *lockChannel) Set(locked bool) error {
+	return channel.device.SetLocked(locked)
+}
+
+//
+// Door locks only accept commands with the security command class, so the
+// driver must be configured with a network key and the lock must have been
+// included securely.
+//
+func LockFactory(driver spi.Driver, node openzwave.Node) openzwave.Device {
+	device := &lock{}
+
+	device.Init(driver, node)
+
+	(*device.Info.Signatures)["ninja:thingType"] = "lock"
+
+	device.lockEmitter = device.Reporter("lock", spi.DefaultPolicy, func(locked utils.Equatable) {
+		device.lockChannel.SendState(locked.(*utils.WrappedBool).Unwrap())
+	})
+
+	device.batteryEmitter = device.Reporter("battery", spi.DefaultPolicy, func(level utils.Equatable) {
+		device.batteryChannel.SendState(level.(*utils.WrappedUint8).Float())
+	})
+
+	return device
+}
+
+// ZWave protocols
+
+func (device *lock) NodeAdded() {
+	node := device.Node()
+	api := device.Driver.ZWave()
+	conn := device.Driver.Connection()
+
+	if device.IsExported() {
+		// the node has been re-added, the channels exported for the
+		// previous incarnation of the node are reused.
+		device.exportBattery()
+		device.startPolling()
+		return
+	}
+
+	if _, ok := node.GetValueWithId(door_lock).GetBool(); !ok {
+		api.Logger().Warningf("lock state of node: %v is unavailable - check that a networkKey is configured and the lock was included securely", node)
+	}
+
+	if !device.Export(device) {
+		return
+	}
+
+	device.lockChannel = &lockChannel{spi.NewStateChannel("lock"), device}
+	err := conn.ExportChannel(device, device.lockChannel, "lock")
+	if err != nil {
+		api.Logger().Infof("failed to export lock channel for %v: %s", node, err)
+		return
+	}
+
+	device.exportBattery()
+	device.startPolling()
+}
+
+//
+// Export the battery channel if the node reports a battery level. The
+// values of a node are only known once the node has been added, so this is
+// decided each time the node is added rather than when the device is built.
+//
+func (device *lock) exportBattery() {
+	node := device.Node()
+	api := device.Driver.ZWave()
+	conn := device.Driver.Connection()
+
+	if device.batteryChannel != nil {
+		return
+	}
+	if _, ok := node.GetValueWithId(battery_sensor).GetUint8(); !ok {
+		return
+	}
+
+	batteryChannel := channels.NewBatteryChannel(device)
+	err := conn.ExportChannel(device, batteryChannel, "battery")
+	if err != nil {
+		api.Logger().Infof("failed to export battery channel for %v: %s", node, err)
+		return
+	}
+	device.batteryChannel = batteryChannel
+}
+
+//
+// The lock state is polled. The battery level changes too slowly to be
+// worth polling a battery powered lock for, so it is refreshed when the
+// node is added and each time the lock is operated, which is what drains
+// the battery.
+//
+func (device *lock) startPolling() {
+	device.Poll(door_lock)
+	device.refreshBattery()
+}
+
+func (device *lock) refreshBattery() {
+	if device.batteryChannel != nil {
+		device.Node().GetValueWithId(battery_sensor).Refresh()
+	}
+}
+
+func (device *lock) NodeChanged() {
+}
+
+func (device *lock) NodeRemoved() {
+	device.Detach()
+}
+
+func (device *lock) ValueChanged(v openzwave.Value) {
+	switch v.Id() {
+	case door_lock:
+		if !device.Refreshed() {
+			device.sendLockState()
+		}
+	case battery_sensor:
+		valB, ok := v.GetUint8()
+		if ok && device.batteryChannel != nil && device.TrackBattery(valB, device.batteryChannel.SendEvent) {
+			device.batteryEmitter.Emit(utils.WrapUint8(valB))
+		}
+	}
+}
+
+// Ninja protocols
+
+func (device *lock) SetLocked(locked bool) error {
+	if err := device.CheckPresent(); err != nil {
+		return err
+	}
+	return device.setDeviceLocked(locked)
+}
+
+//
+// Issue a set against the OpenZWave API, then wait until the refreshed
+// value matches the requested state or until a timeout.
+//
+func (device *lock) setDeviceLocked(locked bool) error {
+
+	val := device.Node().GetValueWithId(door_lock)
+
+	if !val.SetBool(locked) {
+		return fmt.Errorf("Failed to set locked to %v - set failed", locked)
+	}
+
+	err := device.Confirm(val, maxDelay, func(val openzwave.Value) bool {
+		current, ok := val.GetBool()
+		return ok && current == locked
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to set required locked state to %v - %s", locked, err)
+	}
+	device.sendLockState()
+	device.refreshBattery()
+	return nil
+}
+
+func (device *lock) sendLockState() {
+	locked, ok := device.Node().GetValueWithId(door_lock).GetBool()
+	if ok && device.lockChannel != nil {
+		device.lockEmitter.Emit(utils.WrapBool(locked))
+	}
+}
@@ -0,0 +1,108 @@
+package common
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ninjasphere/go-openzwave"
+
+	"github.com/ninjasphere/driver-go-zwave/fake"
+)
+
+var (
+	lockProduct     = openzwave.ProductId{"0090", "0001"}
+	lockDescription = openzwave.ProductDescription{"Kwikset", "SmartCode 910", "Door Lock"}
+)
+
+func newLock(t *testing.T, battery bool) (*fake.Driver, *lock, *fake.Value, *fake.Value) {
+	driver := fake.NewDriver(LockFactory)
+	node := driver.API.NewNode(6, lockProduct, lockDescription)
+	state := node.AddValue(door_lock, false)
+	var level *fake.Value
+	if battery {
+		level = node.AddValue(battery_sensor, uint8(90))
+	}
+	device := driver.API.Join(node).(*lock)
+	if driver.Conn.Channel(device, "lock") == nil {
+		t.Fatal("expected the lock channel to be exported")
+	}
+	return driver, device, state, level
+}
+
+func TestLockWithoutBattery(t *testing.T) {
+	driver, device, _, _ := newLock(t, false)
+
+	if driver.Conn.Channel(device, "battery") != nil {
+		t.Error("expected no battery channel for a lock without a battery level")
+	}
+}
+
+func TestLockBatteryIsRefreshedAndReported(t *testing.T) {
+	driver, device, _, level := newLock(t, true)
+
+	if driver.Conn.Channel(device, "battery") == nil {
+		t.Fatal("expected a battery channel for a lock with a battery level")
+	}
+	if level.Refreshes() != 1 {
+		t.Errorf("expected the battery level to be refreshed when the node is added, got %d refreshes", level.Refreshes())
+	}
+	if level.IsPolled() {
+		t.Error("expected the battery level not to be polled")
+	}
+
+	// the refresh reports the level asynchronously
+	deadline := time.Now().Add(5 * time.Second)
+	for len(driver.Conn.States("battery")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if states := driver.Conn.States("battery"); len(states) != 1 || states[0] != 90.0 {
+		t.Fatalf("expected the refreshed battery level 90 to be reported, got %v", states)
+	}
+
+	driver.Conn.ClearEvents()
+	level.Emit(uint8(85))
+	if states := driver.Conn.States("battery"); len(states) != 1 || states[0] != 85.0 {
+		t.Errorf("expected battery level 85 to be reported, got %v", states)
+	}
+}
+
+func TestLockIsLockedWhenConfirmed(t *testing.T) {
+	driver, device, state, level := newLock(t, true)
+	refreshes := level.Refreshes()
+
+	if err := device.SetLocked(true); err != nil {
+		t.Fatal(err)
+	}
+	if sets := state.Sets(); len(sets) != 1 || sets[0] != true {
+		t.Errorf("expected the lock to be set, got %v", sets)
+	}
+	if states := driver.Conn.States("lock"); len(states) == 0 || states[len(states)-1] != true {
+		t.Errorf("expected the lock to be reported locked, got %v", states)
+	}
+	if level.Refreshes() <= refreshes {
+		t.Error("expected the battery level to be refreshed after the lock was operated")
+	}
+}
+
+func TestLockFailsWhenRejected(t *testing.T) {
+	_, device, state, _ := newLock(t, false)
+	state.SetBehavior(fake.Reject)
+
+	err := device.SetLocked(true)
+	if err == nil || !strings.Contains(err.Error(), "set failed") {
+		t.Errorf("expected the set to fail, got %v", err)
+	}
+}
+
+func TestLockReportsManualOperation(t *testing.T) {
+	driver, _, state, _ := newLock(t, false)
+
+	state.Emit(true)
+	state.Emit(false)
+
+	states := driver.Conn.States("lock")
+	if len(states) != 2 || states[0] != true || states[1] != false {
+		t.Errorf("expected locked then unlocked to be reported, got %v", states)
+	}
+}
@@ -0,0 +1,274 @@
+package common
+
+import (
+	"strings"
+
+	"github.com/ninjasphere/go-openzwave"
+	"github.com/ninjasphere/go-openzwave/CC"
+
+	"github.com/ninjasphere/go-ninja/api"
+	"github.com/ninjasphere/go-ninja/channels"
+
+	"github.com/ninjasphere/driver-go-zwave/spi"
+	"github.com/ninjasphere/driver-go-zwave/utils"
+)
+
+var (
+	binary_sensor = openzwave.ValueID{CC.SENSOR_BINARY, 1, 0}
+	alarm_type    = openzwave.ValueID{CC.ALARM, 1, 0}
+	alarm_level   = openzwave.ValueID{CC.ALARM, 1, 1}
+
+	//
+	// Keywords in the product description that identify the type of a
+	// binary sensor, in order of precedence.
+	//
+	sensorTypeKeywords = []struct {
+		sensorType string
+		keywords   []string
+	}{
+		{"smoke", []string{"smoke"}},
+		{"co", []string{"carbon monoxide", "co detector", "co sensor"}},
+		{"water", []string{"water", "flood", "leak"}},
+		{"motion", []string{"motion", "pir"}},
+		{"contact", []string{"door", "window", "contact"}},
+	}
+)
+
+const (
+	defaultSensorType = "contact"
+)
+
+//
+// AlarmState is the payload of "state" events on the alarm channel. Type
+// and level are the raw values of the ALARM (NOTIFICATION) command class.
+// The channel's protocol is zwave-alarm, since the alarm protocol of smoke,
+// water and co channels has a boolean state.
+//
+type AlarmState struct {
+	Type   uint8 `json:"type"`
+	Level  uint8 `json:"level"`
+	Active bool  `json:"active"`
+}
+
+//
+// A binarySensor is a door/window contact, water leak, smoke or similar
+// sensor. Its state is reported on a single channel whose protocol depends
+// on the sensor type, which is taken from the device configuration or,
+// failing that, guessed from the product description.
+//
+// Sensors that report with the ALARM command class also have an alarm channel
+// that reports the raw alarm type and level. Sensors that have no
+// SENSOR_BINARY value derive their state from the alarm level. The values
+// of a node are only known once the node has been added, so which values
+// the node reports is decided each time the node is added.
+//
+type binarySensor struct {
+	spi.Device
+
+	sensorType string
+	hasBinary  bool // true if the node reports SENSOR_BINARY values
+	hasAlarm   bool // true if the node reports ALARM values
+
+	motionChannel  *channels.MotionChannel
+	motionReporter *spi.MotionReporter
+	stateChannel   *spi.StateChannel
+	stateEmitter   utils.Emitter
+	alarmChannel   *spi.StateChannel
+	alarmEmitter   utils.Emitter
+	batteryChannel *channels.BatteryChannel
+	batteryEmitter utils.Emitter
+}
+
+func BinarySensorFactory(driver spi.Driver, node openzwave.Node) openzwave.Device {
+	device := &binarySensor{}
+
+	device.Init(driver, node)
+
+	device.sensorType = device.Config().SensorType
+	if device.sensorType == "" {
+		description := node.GetProductDescription()
+		device.sensorType = guessSensorType(description.ProductName + " " + description.ProductType)
+	}
+
+	(*device.Info.Signatures)["ninja:thingType"] = "sensor"
+	(*device.Info.Signatures)["zwave:sensorType"] = device.sensorType
+
+	if device.sensorType == "motion" {
+		device.motionReporter = device.MotionReporter("motion", func(motion bool) {
+			if motion {
+				device.motionChannel.SendMotion()
+			} else {
+				device.motionChannel.SendEvent("state", false)
+			}
+		})
+	}
+
+	device.stateEmitter = device.Reporter(device.sensorType, spi.DefaultPolicy, func(state utils.Equatable) {
+		device.sendSensorState(state.(*utils.WrappedBool).Unwrap())
+	})
+
+	device.alarmEmitter = device.Reporter("alarm", spi.DefaultPolicy, func(alarm utils.Equatable) {
+		vals := alarm.(*utils.WrappedComposite).Unwrap()
+		alarmType := vals[0].(*utils.WrappedUint8).Unwrap()
+		level := vals[1].(*utils.WrappedUint8).Unwrap()
+		device.alarmChannel.SendState(&AlarmState{alarmType, level, level != 0})
+	})
+
+	device.batteryEmitter = device.Reporter("battery", spi.DefaultPolicy, func(level utils.Equatable) {
+		device.batteryChannel.SendState(level.(*utils.WrappedUint8).Float())
+	})
+
+	return device
+}
+
+//
+// Answer true if binary sensors support the sensor type.
+//
+func IsSensorType(sensorType string) bool {
+	for _, candidate := range sensorTypeKeywords {
+		if candidate.sensorType == sensorType {
+			return true
+		}
+	}
+	return false
+}
+
+func guessSensorType(description string) string {
+	description = strings.ToLower(description)
+	for _, candidate := range sensorTypeKeywords {
+		for _, keyword := range candidate.keywords {
+			if strings.Contains(description, keyword) {
+				return candidate.sensorType
+			}
+		}
+	}
+	return defaultSensorType
+}
+
+// ZWave protocols
+
+func (device *binarySensor) NodeAdded() {
+	node := device.Node()
+	api := device.Driver.ZWave()
+	conn := device.Driver.Connection()
+
+	_, device.hasBinary = node.GetValueWithId(binary_sensor).GetBool()
+	_, device.hasAlarm = node.GetValueWithId(alarm_level).GetUint8()
+
+	if device.IsExported() {
+		// the node has been re-added, the channels exported for the
+		// previous incarnation of the node are reused.
+		device.exportAlarm()
+		return
+	}
+
+	if !device.Export(device) {
+		return
+	}
+
+	var channel ninja.Channel
+	switch device.sensorType {
+	case "motion":
+		device.motionChannel = channels.NewMotionChannel(device)
+		channel = device.motionChannel
+	case "contact":
+		device.stateChannel = spi.NewStateChannel("contact")
+		channel = device.stateChannel
+	default:
+		device.stateChannel = spi.NewStateChannel("alarm")
+		channel = device.stateChannel
+	}
+	err := conn.ExportChannel(device, channel, device.sensorType)
+	if err != nil {
+		api.Logger().Infof("failed to export %s channel for %v: %s", device.sensorType, node, err)
+		return
+	}
+
+	device.exportAlarm()
+
+	device.batteryChannel = channels.NewBatteryChannel(device)
+	err = conn.ExportChannel(device, device.batteryChannel, "battery")
+	if err != nil {
+		api.Logger().Infof("failed to export battery channel for %v: %s", node, err)
+	}
+}
+
+//
+// Export the alarm channel if the node reports ALARM values.
+//
+func (device *binarySensor) exportAlarm() {
+	node := device.Node()
+	api := device.Driver.ZWave()
+	conn := device.Driver.Connection()
+
+	if device.alarmChannel != nil || !device.hasAlarm {
+		return
+	}
+
+	alarmChannel := spi.NewStateChannel("zwave-alarm")
+	err := conn.ExportChannel(device, alarmChannel, "alarm")
+	if err != nil {
+		api.Logger().Infof("failed to export alarm channel for %v: %s", node, err)
+		return
+	}
+	device.alarmChannel = alarmChannel
+}
+
+func (device *binarySensor) NodeChanged() {
+}
+
+func (device *binarySensor) NodeRemoved() {
+	device.Detach()
+}
+
+func (device *binarySensor) ValueChanged(v openzwave.Value) {
+	switch v.Id() {
+	case binary_sensor:
+		state, ok := v.GetBool()
+		if ok {
+			device.sendState(state)
+		}
+	case alarm_level:
+		level, ok := v.GetUint8()
+		if !ok {
+			return
+		}
+		alarmType, _ := device.Node().GetValueWithId(alarm_type).GetUint8()
+		if device.alarmChannel != nil {
+			device.alarmEmitter.Emit(utils.WrapComposite(utils.WrapUint8(alarmType), utils.WrapUint8(level)))
+		}
+		if !device.hasBinary {
+			device.sendState(level != 0)
+		}
+	case battery_sensor:
+		valB, ok := v.GetUint8()
+		if ok && device.batteryChannel != nil && device.TrackBattery(valB, device.batteryChannel.SendEvent) {
+			device.batteryEmitter.Emit(utils.WrapUint8(valB))
+		}
+	}
+}
+
+//
+// Reflect the state of the sensor onto the sensor channel. Contact sensors
+// report "open" or "closed", motion sensors report motion and that motion
+// has cleared, and alarm sensors report true while the alarm is active.
+//
+func (device *binarySensor) sendState(state bool) {
+	switch {
+	case device.motionChannel != nil:
+		device.motionReporter.Report(state)
+	case device.stateChannel != nil:
+		device.stateEmitter.Emit(utils.WrapBool(state))
+	}
+}
+
+func (device *binarySensor) sendSensorState(state bool) {
+	switch {
+	case device.sensorType != "contact":
+		device.stateChannel.SendState(state)
+	case state:
+		device.stateChannel.SendState("open")
+	default:
+		device.stateChannel.SendState("closed")
+	}
+}
@@ -0,0 +1,139 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ninjasphere/go-openzwave"
+
+	"github.com/ninjasphere/driver-go-zwave/fake"
+	"github.com/ninjasphere/driver-go-zwave/spi"
+)
+
+var (
+	sensorProduct     = openzwave.ProductId{"0086", "0070"}
+	motionDescription = openzwave.ProductDescription{"Acme", "PIR Motion Sensor", "Sensor"}
+)
+
+func TestMotionSensorReportsMotionClearing(t *testing.T) {
+	driver := fake.NewDriver(BinarySensorFactory)
+	node := driver.API.NewNode(3, sensorProduct, motionDescription)
+	motion := node.AddValue(binary_sensor, false)
+
+	device := driver.API.Join(node).(*binarySensor)
+	if device.sensorType != "motion" {
+		t.Fatalf("expected a motion sensor, got %s", device.sensorType)
+	}
+
+	motion.Emit(true)
+	motion.Emit(false)
+
+	expected := []interface{}{true, false}
+	if states := driver.Conn.States("motion"); !reflect.DeepEqual(states, expected) {
+		t.Errorf("expected motion states %v, got %v", expected, states)
+	}
+}
+
+func TestMotionTimeoutClearsMotion(t *testing.T) {
+	clock := fake.NewClock()
+	driver := fake.NewDriver(BinarySensorFactory)
+	driver.SetClock(clock)
+	node := driver.API.NewNode(3, sensorProduct, motionDescription)
+	motion := node.AddValue(binary_sensor, false)
+
+	driver.Names().Assign("cafebabe:003:0086:0070", "motion-sensor")
+	driver.Devices["motion-sensor"] = &spi.DeviceConfig{MotionTimeout: 60}
+	driver.API.Join(node)
+
+	motion.Emit(true)
+	clock.Advance(59 * time.Second)
+	if states := driver.Conn.States("motion"); !reflect.DeepEqual(states, []interface{}{true}) {
+		t.Fatalf("expected motion to be reported, got %v", states)
+	}
+
+	clock.Advance(time.Second)
+	if states := driver.Conn.States("motion"); !reflect.DeepEqual(states, []interface{}{true, false}) {
+		t.Errorf("expected motion to be cleared after the timeout, got %v", states)
+	}
+}
+
+func TestMotionIsNotRepublished(t *testing.T) {
+	driver := fake.NewDriver(BinarySensorFactory)
+	node := driver.API.NewNode(3, sensorProduct, motionDescription)
+	motion := node.AddValue(binary_sensor, false)
+
+	device := driver.API.Join(node).(*binarySensor)
+	motion.Emit(true)
+	device.Republish()
+
+	if states := driver.Conn.States("motion"); !reflect.DeepEqual(states, []interface{}{true}) {
+		t.Errorf("expected motion to be reported once, got %v", states)
+	}
+}
+
+func TestContactStateIsRepublished(t *testing.T) {
+	driver := fake.NewDriver(BinarySensorFactory)
+	node := driver.API.NewNode(3, sensorProduct, openzwave.ProductDescription{"Acme", "Door Sensor", "Sensor"})
+	contact := node.AddValue(binary_sensor, false)
+
+	device := driver.API.Join(node).(*binarySensor)
+	contact.Emit(true)
+	device.Republish()
+
+	expected := []interface{}{"open", "open"}
+	if states := driver.Conn.States("contact"); !reflect.DeepEqual(states, expected) {
+		t.Errorf("expected contact states %v, got %v", expected, states)
+	}
+}
+
+//
+// The values of a node may not be known when its device is built, only
+// once the node has been added.
+//
+func TestSmokeAlarmFoundWhenNodeAdded(t *testing.T) {
+	var level *fake.Value
+	driver := fake.NewDriver(func(driver spi.Driver, node openzwave.Node) openzwave.Device {
+		device := BinarySensorFactory(driver, node)
+		node.(*fake.Node).AddValue(alarm_type, uint8(1))
+		level = node.(*fake.Node).AddValue(alarm_level, uint8(0))
+		return device
+	})
+	node := driver.API.NewNode(3, sensorProduct, openzwave.ProductDescription{"Acme", "Smoke Detector", "Sensor"})
+
+	device := driver.API.Join(node).(*binarySensor)
+
+	smoke := driver.Conn.Channel(device, "smoke")
+	alarm := driver.Conn.Channel(device, "alarm")
+	if smoke == nil || alarm == nil {
+		t.Fatal("expected smoke and alarm channels for a sensor with alarm values")
+	}
+	if smoke.GetProtocol() != "alarm" || alarm.GetProtocol() != "zwave-alarm" {
+		t.Errorf("expected protocols alarm and zwave-alarm, got %s and %s", smoke.GetProtocol(), alarm.GetProtocol())
+	}
+
+	level.Emit(uint8(0xFF))
+
+	if states := driver.Conn.States("smoke"); !reflect.DeepEqual(states, []interface{}{true}) {
+		t.Errorf("expected the smoke state to follow the alarm level, got %v", states)
+	}
+	expected := []interface{}{&AlarmState{Type: 1, Level: 0xFF, Active: true}}
+	if states := driver.Conn.States("alarm"); !reflect.DeepEqual(states, expected) {
+		t.Errorf("expected alarm states %v, got %v", expected, states)
+	}
+}
+
+func TestSensorWithoutAlarm(t *testing.T) {
+	driver := fake.NewDriver(BinarySensorFactory)
+	node := driver.API.NewNode(3, sensorProduct, openzwave.ProductDescription{"Acme", "Flood Sensor", "Sensor"})
+	node.AddValue(binary_sensor, false)
+
+	device := driver.API.Join(node).(*binarySensor)
+
+	if driver.Conn.Channel(device, "water") == nil {
+		t.Error("expected a water channel")
+	}
+	if driver.Conn.Channel(device, "alarm") != nil {
+		t.Error("expected no alarm channel for a sensor without alarm values")
+	}
+}
@@ -0,0 +1,271 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ninjasphere/go-openzwave"
+	"github.com/ninjasphere/go-openzwave/CC"
+
+	"github.com/ninjasphere/driver-go-zwave/spi"
+	"github.com/ninjasphere/driver-go-zwave/utils"
+)
+
+const (
+	maxShadeLevel = 99 // the level of a fully open shade
+
+	fibaroManufacturerId        = "010F"
+	fibaroCalibrationParameter  = 29 // FGRM-222 roller shutter: 1 starts calibration, reset to 0 when done
+	calibrationStateUnknown     = "unknown"
+	calibrationStateCalibrating = "calibrating"
+	calibrationStateCalibrated  = "calibrated"
+)
+
+var (
+	shade_level = openzwave.ValueID{CC.SWITCH_MULTILEVEL, 1, 0}
+	shade_open  = openzwave.ValueID{CC.SWITCH_MULTILEVEL, 1, 1} // "Bright" button, held to raise the level
+	shade_close = openzwave.ValueID{CC.SWITCH_MULTILEVEL, 1, 2} // "Dim" button, held to lower the level
+
+	shadeKeywords = []string{"shutter", "blind", "shade", "curtain", "awning"}
+)
+
+//
+// Answer true if the product description of the node suggests that its
+// multilevel switch drives a window covering rather than a light.
+//
+func IsShade(node openzwave.Node) bool {
+	description := node.GetProductDescription()
+	text := strings.ToLower(description.ProductName + " " + description.ProductType)
+	for _, keyword := range shadeKeywords {
+		if strings.Contains(text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// ShadeState is the payload of "state" events on the shade channel.
+// Position is 0 when fully closed and 1 when fully open.
+//
+type ShadeState struct {
+	Position    float64 `json:"position"`
+	Calibration string  `json:"calibration"`
+}
+
+type shade struct {
+	spi.Device
+
+	shadeChannel *shadeChannel
+	shadeEmitter utils.Emitter
+
+	calibrationParameter uint8 // the configuration parameter that starts calibration, 0 if none
+
+	mutex       sync.Mutex // guards calibration
+	calibration string
+}
+
+type shadeChannel struct {
+	*spi.StateChannel
+	device *shade
+}
+
+func (channel *shadeChannel) Set(position float64) error {
+	return channel.device.SetPosition(position)
+}
+
+func (channel *shadeChannel) Open() error {
+	return channel.device.StartLevelChange(true)
+}
+
+func (channel *shadeChannel) Close() error {
+	return channel.device.StartLevelChange(false)
+}
+
+func (channel *shadeChannel) Stop() error {
+	return channel.device.StopLevelChange()
+}
+
+func (channel *shadeChannel) Calibrate() error {
+	return channel.device.Calibrate()
+}
+
+func ShadeFactory(driver spi.Driver, node openzwave.Node) openzwave.Device {
+	device := &shade{}
+
+	device.Init(driver, node)
+
+	device.calibration = calibrationStateUnknown
+	device.calibrationParameter = device.Config().CalibrationParameter
+	if device.calibrationParameter == 0 && node.GetProductId().ManufacturerId == fibaroManufacturerId {
+		device.calibrationParameter = fibaroCalibrationParameter
+	}
+
+	(*device.Info.Signatures)["ninja:thingType"] = "shade"
+
+	device.shadeEmitter = device.Reporter("shade", spi.DefaultPolicy, func(state utils.Equatable) {
+		vals := state.(*utils.WrappedComposite).Unwrap()
+		device.shadeChannel.SendState(&ShadeState{
+			Position:    vals[0].(*utils.WrappedUint8).Float() / maxShadeLevel,
+			Calibration: vals[1].(*utils.WrappedString).Unwrap(),
+		})
+	})
+
+	return device
+}
+
+// ZWave protocols
+
+func (device *shade) NodeAdded() {
+	node := device.Node()
+	api := device.Driver.ZWave()
+	conn := device.Driver.Connection()
+
+	if device.IsExported() {
+		// the node has been re-added, the channels exported for the
+		// previous incarnation of the node are reused.
+		device.startPolling()
+		return
+	}
+
+	if !device.Export(device) {
+		return
+	}
+
+	device.shadeChannel = &shadeChannel{spi.NewStateChannel("shade"), device}
+	err := conn.ExportChannel(device, device.shadeChannel, "shade")
+	if err != nil {
+		api.Logger().Infof("failed to export shade channel for %v: %s", node, err)
+		return
+	}
+
+	device.startPolling()
+}
+
+func (device *shade) startPolling() {
+	device.Poll(shade_level)
+}
+
+func (device *shade) NodeChanged() {
+}
+
+func (device *shade) NodeRemoved() {
+	device.Detach()
+}
+
+func (device *shade) ValueChanged(v openzwave.Value) {
+	switch v.Id() {
+	case shade_level:
+		device.sendShadeState()
+	case device.calibrationValue():
+		flag, ok := v.GetString()
+		if ok && flag == "0" && device.finishCalibration() {
+			device.sendShadeState()
+		}
+	}
+}
+
+// Ninja protocols
+
+func (device *shade) SetPosition(position float64) error {
+	if err := device.CheckPresent(); err != nil {
+		return err
+	}
+	if position < 0 {
+		position = 0
+	} else if position > 1.0 {
+		position = 1.0
+	}
+	level := uint8(position * maxShadeLevel)
+	val := device.Node().GetValueWithId(shade_level)
+	if !val.SetUint8(level) {
+		return fmt.Errorf("Failed to set level to %d - set failed", level)
+	}
+	val.Refresh()
+	return nil
+}
+
+//
+// Start moving the shade up (open) or down (close). The shade moves until it
+// reaches the end of its travel or StopLevelChange is called.
+//
+func (device *shade) StartLevelChange(open bool) error {
+	if err := device.CheckPresent(); err != nil {
+		return err
+	}
+	button := shade_close
+	if open {
+		button = shade_open
+	}
+	if !device.Node().GetValueWithId(button).SetBool(true) {
+		return fmt.Errorf("Failed to start level change - set failed")
+	}
+	return nil
+}
+
+func (device *shade) StopLevelChange() error {
+	if err := device.CheckPresent(); err != nil {
+		return err
+	}
+	opened := device.Node().GetValueWithId(shade_open).SetBool(false)
+	closed := device.Node().GetValueWithId(shade_close).SetBool(false)
+	if !opened || !closed {
+		return fmt.Errorf("Failed to stop level change - set failed")
+	}
+	device.Node().GetValueWithId(shade_level).Refresh()
+	return nil
+}
+
+//
+// Start the shade's calibration run, which drives the shade through its full
+// travel to learn its end positions.
+//
+func (device *shade) Calibrate() error {
+	if err := device.CheckPresent(); err != nil {
+		return err
+	}
+	if device.calibrationParameter == 0 {
+		return fmt.Errorf("Calibration is not supported - no calibrationParameter is configured")
+	}
+	if !device.Node().GetValueWithId(device.calibrationValue()).SetString("1") {
+		return fmt.Errorf("Failed to start calibration - set failed")
+	}
+	device.mutex.Lock()
+	device.calibration = calibrationStateCalibrating
+	device.mutex.Unlock()
+
+	device.sendShadeState()
+	return nil
+}
+
+//
+// Answer true if a calibration run was in progress, and is now finished.
+//
+func (device *shade) finishCalibration() bool {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	if device.calibration != calibrationStateCalibrating {
+		return false
+	}
+	device.calibration = calibrationStateCalibrated
+	return true
+}
+
+func (device *shade) calibrationValue() openzwave.ValueID {
+	return openzwave.ValueID{CC.CONFIGURATION, 1, device.calibrationParameter}
+}
+
+func (device *shade) sendShadeState() {
+	level, ok := device.Node().GetValueWithId(shade_level).GetUint8()
+	if ok && device.shadeChannel != nil {
+		if level > maxShadeLevel {
+			level = maxShadeLevel
+		}
+		device.mutex.Lock()
+		calibration := device.calibration
+		device.mutex.Unlock()
+
+		device.shadeEmitter.Emit(utils.WrapComposite(utils.WrapUint8(level), utils.WrapString(calibration)))
+	}
+}
@@ -0,0 +1,39 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/ninjasphere/go-openzwave"
+	"github.com/ninjasphere/go-openzwave/CC"
+
+	"github.com/ninjasphere/driver-go-zwave/fake"
+)
+
+var (
+	shadeProduct     = openzwave.ProductId{fibaroManufacturerId, "1000"}
+	shadeDescription = openzwave.ProductDescription{"Fibaro", "FGRM-222 Roller Shutter", "Shutter"}
+)
+
+func TestShadeCalibration(t *testing.T) {
+	driver := fake.NewDriver(ShadeFactory)
+	node := driver.API.NewNode(4, shadeProduct, shadeDescription)
+	node.AddValue(shade_level, uint8(0))
+	flag := node.AddValue(openzwave.ValueID{CC.CONFIGURATION, 1, fibaroCalibrationParameter}, "0")
+
+	device := driver.API.Join(node).(*shade)
+
+	if err := device.Calibrate(); err != nil {
+		t.Fatal(err)
+	}
+	flag.Emit("0")
+
+	states := driver.Conn.States("shade")
+	if len(states) != 2 {
+		t.Fatalf("expected 2 shade states, got %v", states)
+	}
+	for i, expected := range []string{calibrationStateCalibrating, calibrationStateCalibrated} {
+		if calibration := states[i].(*ShadeState).Calibration; calibration != expected {
+			t.Errorf("expected calibration state %d to be %s, got %s", i, expected, calibration)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+// Provides adapters for device types built on standard command classes, independent of the manufacturer
+package common
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ninjasphere/go-openzwave"
+	"github.com/ninjasphere/go-openzwave/CC"
+
+	"github.com/ninjasphere/go-ninja/channels"
+
+	"github.com/ninjasphere/driver-go-zwave/spi"
+	"github.com/ninjasphere/driver-go-zwave/utils"
+)
+
+const (
+	maxDelay = time.Second * 5 // maximum delay for apply calls
+
+	powerTolerance  = 0.5 // W, changes smaller than this are not reported as changes
+	energyTolerance = 1.0 // Wh
+)
+
+var (
+	binary_switch = openzwave.ValueID{CC.SWITCH_BINARY, 1, 0}
+	energy_meter  = openzwave.ValueID{CC.METER, 1, 0}
+	power_meter   = openzwave.ValueID{CC.METER, 1, 8}
+)
+
+type binarySwitch struct {
+	spi.Device
+
+	onOffChannel  *channels.OnOffChannel
+	powerChannel  *channels.PowerChannel
+	energyChannel *channels.EnergyChannel
+
+	emitter       utils.Emitter
+	powerEmitter  utils.Emitter
+	energyEmitter utils.Emitter
+}
+
+func SwitchFactory(driver spi.Driver, node openzwave.Node) openzwave.Device {
+	device := &binarySwitch{}
+
+	device.Init(driver, node)
+
+	(*device.Info.Signatures)["ninja:thingType"] = "socket"
+
+	device.emitter = device.Reporter("on-off", spi.DefaultPolicy,
+		func(state utils.Equatable) {
+			device.onOffChannel.SendState(state.(*utils.WrappedBool).Unwrap())
+		})
+
+	device.powerEmitter = device.Reporter("power", spi.DefaultPolicy,
+		func(reading utils.Equatable) {
+			device.powerChannel.SendState(reading.(*utils.WrappedFloat).Unwrap())
+		})
+
+	device.energyEmitter = device.Reporter("energy", spi.DefaultPolicy,
+		func(reading utils.Equatable) {
+			device.energyChannel.SendState(reading.(*utils.WrappedFloat).Unwrap())
+		})
+
+	return device
+}
+
+// ZWave protocols
+
+func (device *binarySwitch) NodeAdded() {
+
+	node := device.Node()
+	api := device.Driver.ZWave()
+	conn := device.Driver.Connection()
+
+	if device.IsExported() {
+		// the node has been re-added, the channels exported for the
+		// previous incarnation of the node are reused.
+		device.exportMeters()
+		device.startPolling()
+		return
+	}
+
+	if !device.Export(device) {
+		return
+	}
+
+	device.onOffChannel = channels.NewOnOffChannel(device)
+	err := conn.ExportChannel(device, device.onOffChannel, "on-off")
+	if err != nil {
+		api.Logger().Infof("failed to export on-off channel for %v: %s", node, err)
+		return
+	}
+
+	device.exportMeters()
+	device.startPolling()
+}
+
+//
+// Export the power and energy channels if the node has a meter. The values
+// of a node are only known once the node has been added, so this is decided
+// each time the node is added rather than when the device is built.
+//
+func (device *binarySwitch) exportMeters() {
+	node := device.Node()
+	api := device.Driver.ZWave()
+	conn := device.Driver.Connection()
+
+	if device.powerChannel != nil {
+		return
+	}
+	if _, ok := node.GetValueWithId(power_meter).GetFloat(); !ok {
+		return
+	}
+
+	powerChannel := channels.NewPowerChannel(device)
+	err := conn.ExportChannel(device, powerChannel, "power")
+	if err != nil {
+		api.Logger().Infof("failed to export power channel for %v: %s", node, err)
+		return
+	}
+	device.powerChannel = powerChannel
+
+	device.energyChannel = channels.NewEnergyChannel(device)
+	err = conn.ExportChannel(device, device.energyChannel, "energy")
+	if err != nil {
+		api.Logger().Infof("failed to export energy channel for %v: %s", node, err)
+	}
+}
+
+func (device *binarySwitch) startPolling() {
+	device.Poll(binary_switch)
+	if device.powerChannel != nil {
+		device.Poll(power_meter, energy_meter)
+	}
+}
+
+func (device *binarySwitch) NodeChanged() {
+}
+
+func (device *binarySwitch) NodeRemoved() {
+	device.Detach()
+}
+
+func (device *binarySwitch) ValueChanged(v openzwave.Value) {
+	switch v.Id() {
+	case binary_switch:
+		if !device.Refreshed() {
+			device.sendSwitchState()
+		}
+	case power_meter:
+		readingW, ok := v.GetFloat()
+		if ok && device.powerChannel != nil {
+			device.powerEmitter.Emit(utils.WrapFloatWithTolerance(readingW, powerTolerance))
+		}
+	case energy_meter:
+		readingKWH, ok := v.GetFloat()
+		if ok && device.energyChannel != nil {
+			watts := readingKWH * 1000
+			device.energyEmitter.Emit(utils.WrapFloatWithTolerance(watts, energyTolerance))
+		}
+	}
+}
+
+// Ninja protocols
+
+func (device *binarySwitch) SetOnOff(state bool) error {
+	if err := device.CheckPresent(); err != nil {
+		return err
+	}
+	return device.setDeviceState(state)
+}
+
+func (device *binarySwitch) ToggleOnOff() error {
+	if err := device.CheckPresent(); err != nil {
+		return err
+	}
+	state, ok := device.Node().GetValueWithId(binary_switch).GetBool()
+	if !ok {
+		return fmt.Errorf("Unable to determine current state of switch")
+	}
+	return device.setDeviceState(!state)
+}
+
+//
+// Issue a set against the OpenZWave API, then wait until the refreshed
+// value matches the requested state or until a timeout.
+//
+func (device *binarySwitch) setDeviceState(state bool) error {
+
+	val := device.Node().GetValueWithId(binary_switch)
+
+	if !val.SetBool(state) {
+		return fmt.Errorf("Failed to set state to %v - set failed", state)
+	}
+
+	err := device.Confirm(val, maxDelay, func(val openzwave.Value) bool {
+		current, ok := val.GetBool()
+		return ok && current == state
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to set required state to %v - %s", state, err)
+	}
+	device.emitter.Reset()
+	device.sendSwitchState()
+	return nil
+}
+
+//
+// This call is used to reflect notifications about the current
+// state of the switch back to the ninja network
+//
+func (device *binarySwitch) sendSwitchState() {
+	state, ok := device.Node().GetValueWithId(binary_switch).GetBool()
+	if ok {
+		device.emitter.Emit(utils.WrapBool(state))
+	}
+}
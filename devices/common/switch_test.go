@@ -0,0 +1,68 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/ninjasphere/go-openzwave"
+
+	"github.com/ninjasphere/driver-go-zwave/fake"
+	"github.com/ninjasphere/driver-go-zwave/spi"
+)
+
+var (
+	switchProduct     = openzwave.ProductId{"0086", "0003"}
+	switchDescription = openzwave.ProductDescription{"Aeon Labs", "Smart Energy Switch", "Switch"}
+)
+
+func TestSwitchWithoutMeter(t *testing.T) {
+	driver := fake.NewDriver(SwitchFactory)
+	node := driver.API.NewNode(2, switchProduct, switchDescription)
+	node.AddValue(binary_switch, false)
+
+	device := driver.API.Join(node).(*binarySwitch)
+
+	if driver.Conn.Channel(device, "power") != nil || driver.Conn.Channel(device, "energy") != nil {
+		t.Error("expected no power or energy channel for a switch without a meter")
+	}
+}
+
+//
+// The meter values of a node may not be known when its device is built,
+// only once the node has been added.
+//
+func TestSwitchMeterFoundWhenNodeAdded(t *testing.T) {
+	driver := fake.NewDriver(func(driver spi.Driver, node openzwave.Node) openzwave.Device {
+		device := SwitchFactory(driver, node)
+		node.(*fake.Node).AddValue(power_meter, 0.0)
+		node.(*fake.Node).AddValue(energy_meter, 0.0)
+		return device
+	})
+	node := driver.API.NewNode(2, switchProduct, switchDescription)
+	node.AddValue(binary_switch, false)
+
+	device := driver.API.Join(node).(*binarySwitch)
+
+	if driver.Conn.Channel(device, "power") == nil || driver.Conn.Channel(device, "energy") == nil {
+		t.Fatal("expected power and energy channels for a metered switch")
+	}
+	if !node.GetValueWithId(power_meter).(*fake.Value).IsPolled() {
+		t.Error("expected the power meter to be polled")
+	}
+}
+
+func TestSwitchIgnoresPowerChangesWithinTolerance(t *testing.T) {
+	driver := fake.NewDriver(SwitchFactory)
+	node := driver.API.NewNode(2, switchProduct, switchDescription)
+	node.AddValue(binary_switch, false)
+	power := node.AddValue(power_meter, 0.0)
+	driver.API.Join(node)
+
+	power.Emit(10.0)
+	power.Emit(10.3)
+	power.Emit(11.0)
+
+	states := driver.Conn.States("power")
+	if len(states) != 2 || states[0] != 10.0 || states[1] != 11.0 {
+		t.Errorf("expected power 10 and 11 to be reported, got %v", states)
+	}
+}
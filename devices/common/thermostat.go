@@ -0,0 +1,224 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ninjasphere/go-openzwave"
+	"github.com/ninjasphere/go-openzwave/CC"
+
+	"github.com/ninjasphere/go-ninja/channels"
+
+	"github.com/ninjasphere/driver-go-zwave/spi"
+	"github.com/ninjasphere/driver-go-zwave/utils"
+)
+
+var (
+	temperature_sensor = openzwave.ValueID{CC.SENSOR_MULTILEVEL, 1, 1}
+	heating_setpoint   = openzwave.ValueID{CC.THERMOSTAT_SETPOINT, 1, 1}
+	cooling_setpoint   = openzwave.ValueID{CC.THERMOSTAT_SETPOINT, 1, 2}
+	thermostat_mode    = openzwave.ValueID{CC.THERMOSTAT_MODE, 1, 0}
+	operating_state    = openzwave.ValueID{CC.THERMOSTAT_OPERATING_STATE, 1, 0}
+
+	// the OpenZWave labels of the common thermostat modes
+	modeLabels = map[string]string{
+		"off":  "Off",
+		"heat": "Heat",
+		"cool": "Cool",
+		"auto": "Auto",
+	}
+)
+
+type thermostat struct {
+	spi.Device
+
+	temperatureChannel *channels.TemperatureChannel
+	temperatureEmitter utils.Emitter
+	setpointChannel    *setpointChannel
+	setpointEmitter    utils.Emitter
+	modeChannel        *modeChannel
+	modeEmitter        utils.Emitter
+}
+
+//
+// The setpoint channel reports, and sets, the setpoint that applies to the
+// current mode of the thermostat.
+//
+type setpointChannel struct {
+	*spi.StateChannel
+	device *thermostat
+}
+
+func (channel *setpointChannel) Set(temperature float64) error {
+	return channel.device.SetSetpoint(temperature)
+}
+
+//
+// The mode channel reports, and sets, the mode of the thermostat. Changes to
+// the operating state of the thermostat (e.g. "heating", "idle") are sent as
+// "operatingState" events on the mode channel.
+//
+type modeChannel struct {
+	*spi.StateChannel
+	device *thermostat
+}
+
+func (channel *modeChannel) Set(mode string) error {
+	return channel.device.SetMode(mode)
+}
+
+func ThermostatFactory(driver spi.Driver, node openzwave.Node) openzwave.Device {
+	device := &thermostat{}
+
+	device.Init(driver, node)
+
+	(*device.Info.Signatures)["ninja:thingType"] = "thermostat"
+
+	device.temperatureEmitter = device.Reporter("temperature", spi.DefaultPolicy, func(reading utils.Equatable) {
+		device.temperatureChannel.SendState(reading.(*utils.WrappedFloat).Unwrap())
+	})
+
+	device.setpointEmitter = device.Reporter("setpoint", spi.DefaultPolicy, func(setpoint utils.Equatable) {
+		device.setpointChannel.SendState(setpoint.(*utils.WrappedFloat).Unwrap())
+	})
+
+	device.modeEmitter = device.Reporter("mode", spi.DefaultPolicy, func(mode utils.Equatable) {
+		device.modeChannel.SendState(mode.(*utils.WrappedString).Unwrap())
+	})
+
+	return device
+}
+
+// ZWave protocols
+
+func (device *thermostat) NodeAdded() {
+	node := device.Node()
+	api := device.Driver.ZWave()
+	conn := device.Driver.Connection()
+
+	if device.IsExported() {
+		// the node has been re-added, the channels exported for the
+		// previous incarnation of the node are reused.
+		device.startPolling()
+		return
+	}
+
+	if !device.Export(device) {
+		return
+	}
+
+	device.temperatureChannel = channels.NewTemperatureChannel(device)
+	err := conn.ExportChannel(device, device.temperatureChannel, "temperature")
+	if err != nil {
+		api.Logger().Infof("failed to export temperature channel for %v: %s", node, err)
+		return
+	}
+
+	device.setpointChannel = &setpointChannel{spi.NewStateChannel("thermostat"), device}
+	err = conn.ExportChannel(device, device.setpointChannel, "setpoint")
+	if err != nil {
+		api.Logger().Infof("failed to export setpoint channel for %v: %s", node, err)
+		return
+	}
+
+	device.modeChannel = &modeChannel{spi.NewStateChannel("thermostat-mode"), device}
+	err = conn.ExportChannel(device, device.modeChannel, "mode")
+	if err != nil {
+		api.Logger().Infof("failed to export mode channel for %v: %s", node, err)
+		return
+	}
+
+	device.startPolling()
+}
+
+func (device *thermostat) startPolling() {
+	device.Poll(temperature_sensor, heating_setpoint, cooling_setpoint, thermostat_mode, operating_state)
+}
+
+func (device *thermostat) NodeChanged() {
+}
+
+func (device *thermostat) NodeRemoved() {
+	device.Detach()
+}
+
+func (device *thermostat) ValueChanged(v openzwave.Value) {
+	switch v.Id() {
+	case temperature_sensor:
+		valF, ok := v.GetFloat()
+		if ok && device.temperatureChannel != nil {
+			device.temperatureEmitter.Emit(utils.WrapFloat(valF))
+		}
+	case heating_setpoint, cooling_setpoint:
+		if v.Id() == device.currentSetpoint() {
+			valF, ok := v.GetFloat()
+			if ok && device.setpointChannel != nil {
+				device.setpointEmitter.Emit(utils.WrapFloat(valF))
+			}
+		}
+	case thermostat_mode:
+		mode, ok := v.GetString()
+		if ok && device.modeChannel != nil {
+			device.modeEmitter.Emit(utils.WrapString(strings.ToLower(mode)))
+			device.sendSetpoint()
+		}
+	case operating_state:
+		state, ok := v.GetString()
+		if ok && device.modeChannel != nil {
+			device.modeChannel.SendEvent("operatingState", strings.ToLower(state))
+		}
+	}
+}
+
+// Ninja protocols
+
+func (device *thermostat) SetSetpoint(temperature float64) error {
+	if err := device.CheckPresent(); err != nil {
+		return err
+	}
+	id := device.currentSetpoint()
+	return device.Queue("setpoint", func() error {
+		val := device.Node().GetValueWithId(id)
+		if !val.SetFloat(temperature) {
+			return fmt.Errorf("Failed to set setpoint to %v - set failed", temperature)
+		}
+		val.Refresh()
+		return nil
+	})
+}
+
+func (device *thermostat) SetMode(mode string) error {
+	if err := device.CheckPresent(); err != nil {
+		return err
+	}
+	label, ok := modeLabels[strings.ToLower(mode)]
+	if !ok {
+		label = mode
+	}
+	return device.Queue("mode", func() error {
+		val := device.Node().GetValueWithId(thermostat_mode)
+		if !val.SetString(label) {
+			return fmt.Errorf("Failed to set mode to %s - set failed", mode)
+		}
+		val.Refresh()
+		return nil
+	})
+}
+
+//
+// Answer the setpoint that applies in the current mode of the thermostat.
+//
+func (device *thermostat) currentSetpoint() openzwave.ValueID {
+	mode, ok := device.Node().GetValueWithId(thermostat_mode).GetString()
+	if ok && strings.HasPrefix(strings.ToLower(mode), "cool") {
+		return cooling_setpoint
+	}
+	return heating_setpoint
+}
+
+func (device *thermostat) sendSetpoint() {
+	valF, ok := device.Node().GetValueWithId(device.currentSetpoint()).GetFloat()
+	if ok && device.setpointChannel != nil {
+		device.setpointEmitter.Emit(utils.WrapFloat(valF))
+	}
+}
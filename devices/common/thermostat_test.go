@@ -0,0 +1,135 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ninjasphere/go-openzwave"
+
+	"github.com/ninjasphere/driver-go-zwave/fake"
+)
+
+var (
+	thermostatProduct     = openzwave.ProductId{"0098", "0107"}
+	thermostatDescription = openzwave.ProductDescription{"Radio Thermostat", "CT100 Thermostat", "Thermostat"}
+)
+
+type testThermostat struct {
+	driver      *fake.Driver
+	device      *thermostat
+	temperature *fake.Value
+	heating     *fake.Value
+	cooling     *fake.Value
+	mode        *fake.Value
+	state       *fake.Value
+}
+
+func newThermostat(t *testing.T) *testThermostat {
+	driver := fake.NewDriver(ThermostatFactory)
+	node := driver.API.NewNode(5, thermostatProduct, thermostatDescription)
+	tt := &testThermostat{
+		driver:      driver,
+		temperature: node.AddValue(temperature_sensor, 20.5),
+		heating:     node.AddValue(heating_setpoint, 21.0),
+		cooling:     node.AddValue(cooling_setpoint, 25.0),
+		mode:        node.AddValue(thermostat_mode, "Heat"),
+		state:       node.AddValue(operating_state, "Idle"),
+	}
+	tt.device = driver.API.Join(node).(*thermostat)
+	for _, id := range []string{"temperature", "setpoint", "mode"} {
+		if driver.Conn.Channel(tt.device, id) == nil {
+			t.Fatalf("expected the %s channel to be exported", id)
+		}
+	}
+	return tt
+}
+
+func TestThermostatReportsTemperature(t *testing.T) {
+	tt := newThermostat(t)
+
+	tt.temperature.Emit(21.5)
+
+	states := tt.driver.Conn.States("temperature")
+	if len(states) != 1 || states[0] != 21.5 {
+		t.Errorf("expected temperature 21.5 to be reported, got %v", states)
+	}
+}
+
+func TestThermostatReportsSetpointOfCurrentMode(t *testing.T) {
+	tt := newThermostat(t)
+
+	tt.heating.Emit(22.0)
+	tt.cooling.Emit(26.0)
+	if states := tt.driver.Conn.States("setpoint"); len(states) != 1 || states[0] != 22.0 {
+		t.Fatalf("expected only the heating setpoint to be reported while heating, got %v", states)
+	}
+
+	tt.mode.Emit("Cool")
+
+	if states := tt.driver.Conn.States("mode"); len(states) != 1 || states[0] != "cool" {
+		t.Errorf("expected mode cool to be reported, got %v", states)
+	}
+	if states := tt.driver.Conn.States("setpoint"); len(states) != 2 || states[1] != 26.0 {
+		t.Errorf("expected the cooling setpoint to be reported once cooling, got %v", states)
+	}
+}
+
+func TestThermostatSetsSetpointOfCurrentMode(t *testing.T) {
+	tt := newThermostat(t)
+
+	if err := tt.driver.Conn.Call(tt.device, "setpoint", "set", []json.RawMessage{json.RawMessage("23")}); err != nil {
+		t.Fatal(err)
+	}
+	if sets := tt.heating.Sets(); len(sets) != 1 || sets[0] != 23.0 {
+		t.Errorf("expected the heating setpoint to be set to 23, got %v", sets)
+	}
+
+	tt.mode.Update("Cool")
+	if err := tt.device.SetSetpoint(24); err != nil {
+		t.Fatal(err)
+	}
+	if sets := tt.cooling.Sets(); len(sets) != 1 || sets[0] != 24.0 {
+		t.Errorf("expected the cooling setpoint to be set to 24, got %v", sets)
+	}
+}
+
+func TestThermostatSetsModeByLabel(t *testing.T) {
+	tt := newThermostat(t)
+
+	if err := tt.driver.Conn.Call(tt.device, "mode", "set", []json.RawMessage{json.RawMessage(`"cool"`)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tt.device.SetMode("Energy Save Heat"); err != nil {
+		t.Fatal(err)
+	}
+
+	sets := tt.mode.Sets()
+	if len(sets) != 2 || sets[0] != "Cool" || sets[1] != "Energy Save Heat" {
+		t.Errorf("expected the OpenZWave labels Cool and Energy Save Heat to be set, got %v", sets)
+	}
+}
+
+func TestThermostatSetFailsWhenRejected(t *testing.T) {
+	tt := newThermostat(t)
+	tt.mode.SetBehavior(fake.Reject)
+
+	if err := tt.device.SetMode("off"); err == nil {
+		t.Error("expected the mode set to fail")
+	}
+}
+
+func TestThermostatReportsOperatingState(t *testing.T) {
+	tt := newThermostat(t)
+
+	tt.state.Emit("Heating")
+
+	for _, event := range tt.driver.Conn.Events() {
+		if event.Channel == "mode" && event.Event == "operatingState" {
+			if event.Payload != "heating" {
+				t.Errorf("expected operating state heating, got %v", event.Payload)
+			}
+			return
+		}
+	}
+	t.Error("expected an operatingState event on the mode channel")
+}
@@ -0,0 +1,337 @@
+package generic
+
+import (
+	"fmt"
+
+	"github.com/ninjasphere/go-openzwave"
+	"github.com/ninjasphere/go-openzwave/CC"
+
+	"github.com/ninjasphere/go-ninja/api"
+	"github.com/ninjasphere/go-ninja/channels"
+
+	"github.com/ninjasphere/driver-go-zwave/spi"
+	"github.com/ninjasphere/driver-go-zwave/utils"
+)
+
+const (
+	maxLevel  = 99   // the maximum level of a multilevel switch
+	lastLevel = 0xFF // asks a multilevel switch to return to its last non-zero level
+)
+
+type sensorChannel interface {
+	ninja.Channel
+	SendState(state float64) error
+}
+
+//
+// A kind builds the ninja channel for a mapped value and answers the
+// function that reflects changes to the value onto the channel.
+//
+type kind func(device *device, cm *ChannelMapping) (ninja.Channel, func(openzwave.Value))
+
+var kinds = map[string]kind{
+	"temperature": sensor(func(d *device) sensorChannel { return channels.NewTemperatureChannel(d) }),
+	"humidity":    sensor(func(d *device) sensorChannel { return channels.NewHumidityChannel(d) }),
+	"illuminance": sensor(func(d *device) sensorChannel { return channels.NewIlluminanceChannel(d) }),
+	"power":       sensor(func(d *device) sensorChannel { return channels.NewPowerChannel(d) }),
+	"energy":      sensor(func(d *device) sensorChannel { return channels.NewEnergyChannel(d) }),
+	"battery":     battery,
+	"motion":      motion,
+	"on-off":      onOff,
+	"brightness":  brightness,
+}
+
+type binding struct {
+	*ChannelMapping
+	update func(openzwave.Value)
+}
+
+type device struct {
+	spi.Device
+
+	mapping  *Mapping // nil until probed, for devices built by Fallback
+	bindings map[openzwave.ValueID][]*binding
+	byKind   map[string]*binding
+}
+
+//
+// Answer a device factory that builds devices from the specified mapping.
+//
+func Factory(mapping *Mapping) func(spi.Driver, openzwave.Node) openzwave.Device {
+	return func(driver spi.Driver, node openzwave.Node) openzwave.Device {
+		device := newDevice(driver, node)
+		device.setMapping(mapping)
+		return device
+	}
+}
+
+//
+// A device factory for products that have neither a built-in adapter
+// nor a declarative mapping. The mapping of such a device is derived from
+// the values the node supports when the node is added.
+//
+func Fallback(driver spi.Driver, node openzwave.Node) openzwave.Device {
+	return newDevice(driver, node)
+}
+
+func newDevice(driver spi.Driver, node openzwave.Node) *device {
+	device := &device{
+		bindings: make(map[openzwave.ValueID][]*binding),
+		byKind:   make(map[string]*binding),
+	}
+	device.Init(driver, node)
+	return device
+}
+
+func (device *device) setMapping(mapping *Mapping) {
+	device.mapping = mapping
+
+	thingType := mapping.ThingType
+	if thingType == "" {
+		thingType = "sensor"
+	}
+	(*device.Info.Signatures)["ninja:thingType"] = thingType
+}
+
+// ZWave protocols
+
+func (device *device) NodeAdded() {
+	node := device.Node()
+	api := device.Driver.ZWave()
+	conn := device.Driver.Connection()
+
+	if device.IsExported() {
+		// the node has been re-added, the channels exported for the
+		// previous incarnation of the node are reused.
+		device.startPolling()
+		return
+	}
+
+	if device.mapping == nil {
+		mapping := Probe(node)
+		if len(mapping.Channels) == 0 {
+			api.Logger().Infof("node: %v has no supported command classes - not exported", node)
+			return
+		}
+		device.setMapping(mapping)
+	}
+
+	if !device.Export(device) {
+		return
+	}
+
+	for i := range device.mapping.Channels {
+		cm := &device.mapping.Channels[i]
+		channel, update := kinds[cm.Channel](device, cm)
+		err := conn.ExportChannel(device, channel, cm.GetID())
+		if err != nil {
+			api.Logger().Infof("failed to export %s channel for %v: %s", cm.GetID(), node, err)
+			continue
+		}
+		b := &binding{cm, update}
+		device.bindings[cm.ValueId()] = append(device.bindings[cm.ValueId()], b)
+		device.byKind[cm.Channel] = b
+	}
+
+	device.startPolling()
+}
+
+func (device *device) startPolling() {
+	for id, bindings := range device.bindings {
+		for _, b := range bindings {
+			if b.Poll {
+				device.Poll(id)
+				break
+			}
+		}
+	}
+}
+
+func (device *device) NodeChanged() {
+}
+
+func (device *device) NodeRemoved() {
+	device.Detach()
+}
+
+func (device *device) ValueChanged(v openzwave.Value) {
+	for _, b := range device.bindings[v.Id()] {
+		b.update(v)
+	}
+}
+
+// Ninja protocols
+
+func (device *device) SetOnOff(state bool) error {
+	if err := device.CheckPresent(); err != nil {
+		return err
+	}
+	b, ok := device.byKind["on-off"]
+	if !ok {
+		return fmt.Errorf("Device has no on-off value")
+	}
+	val := device.Node().GetValueWithId(b.ValueId())
+
+	var set bool
+	if b.CommandClass == CC.SWITCH_BINARY {
+		set = val.SetBool(state)
+	} else if state {
+		set = val.SetUint8(lastLevel)
+	} else {
+		set = val.SetUint8(0)
+	}
+	if !set {
+		return fmt.Errorf("Failed to set on-off state to %v - set failed", state)
+	}
+	val.Refresh()
+	return nil
+}
+
+func (device *device) ToggleOnOff() error {
+	if err := device.CheckPresent(); err != nil {
+		return err
+	}
+	b, ok := device.byKind["on-off"]
+	if !ok {
+		return fmt.Errorf("Device has no on-off value")
+	}
+	state, ok := isOn(device.Node().GetValueWithId(b.ValueId()))
+	if !ok {
+		return fmt.Errorf("Unable to determine current state of switch")
+	}
+	return device.SetOnOff(!state)
+}
+
+func (device *device) SetBrightness(state float64) error {
+	if err := device.CheckPresent(); err != nil {
+		return err
+	}
+	b, ok := device.byKind["brightness"]
+	if !ok {
+		return fmt.Errorf("Device has no brightness value")
+	}
+	if state < 0 {
+		state = 0
+	} else if state > 1.0 {
+		state = 1.0
+	}
+	val := device.Node().GetValueWithId(b.ValueId())
+	level := uint8(state * maxLevel)
+	if !val.SetUint8(level) {
+		return fmt.Errorf("Failed to set level to %d - set failed", level)
+	}
+	val.Refresh()
+	return nil
+}
+
+// channel kinds
+
+//
+// Sensor channels report the scaled numeric value, with the reporting
+// policy of the channel. Scale and offset only apply to sensor channels.
+//
+func sensor(build func(d *device) sensorChannel) kind {
+	return func(device *device, cm *ChannelMapping) (ninja.Channel, func(openzwave.Value)) {
+		channel := build(device)
+		emitter := device.Reporter(cm.GetID(), spi.DefaultPolicy, func(reading utils.Equatable) {
+			channel.SendState(reading.(*utils.WrappedFloat).Unwrap())
+		})
+		return channel, func(v openzwave.Value) {
+			raw, ok := numeric(v)
+			if ok {
+				emitter.Emit(utils.WrapFloat(cm.apply(raw)))
+			}
+		}
+	}
+}
+
+//
+// Battery channels are sensor channels whose levels are also tracked by the
+// driver, which reports low batteries and the estimated days remaining.
+//
+func battery(device *device, cm *ChannelMapping) (ninja.Channel, func(openzwave.Value)) {
+	channel := channels.NewBatteryChannel(device)
+	emitter := device.Reporter(cm.GetID(), spi.DefaultPolicy, func(reading utils.Equatable) {
+		channel.SendState(reading.(*utils.WrappedFloat).Unwrap())
+	})
+	return channel, func(v openzwave.Value) {
+		level, ok := v.GetUint8()
+		if ok && device.TrackBattery(level, channel.SendEvent) {
+			emitter.Emit(utils.WrapFloat(cm.apply(float64(level))))
+		}
+	}
+}
+
+func motion(device *device, cm *ChannelMapping) (ninja.Channel, func(openzwave.Value)) {
+	channel := channels.NewMotionChannel(device)
+	reporter := device.MotionReporter(cm.GetID(), func(motion bool) {
+		if motion {
+			channel.SendMotion()
+		} else {
+			channel.SendEvent("state", false)
+		}
+	})
+	return channel, func(v openzwave.Value) {
+		state, ok := isOn(v)
+		if ok {
+			reporter.Report(state)
+		}
+	}
+}
+
+func onOff(device *device, cm *ChannelMapping) (ninja.Channel, func(openzwave.Value)) {
+	channel := channels.NewOnOffChannel(device)
+	emitter := device.Reporter(cm.GetID(), spi.DefaultPolicy, func(state utils.Equatable) {
+		channel.SendState(state.(*utils.WrappedBool).Unwrap())
+	})
+	return channel, func(v openzwave.Value) {
+		state, ok := isOn(v)
+		if ok {
+			emitter.Emit(utils.WrapBool(state))
+		}
+	}
+}
+
+func brightness(device *device, cm *ChannelMapping) (ninja.Channel, func(openzwave.Value)) {
+	channel := channels.NewBrightnessChannel(device)
+	emitter := device.Reporter(cm.GetID(), spi.DefaultPolicy, func(level utils.Equatable) {
+		channel.SendState(level.(*utils.WrappedUint8).Float() / maxLevel)
+	})
+	return channel, func(v openzwave.Value) {
+		level, ok := v.GetUint8()
+		if ok && level != 0 {
+			if level > maxLevel {
+				level = maxLevel
+			}
+			emitter.Emit(utils.WrapUint8(level))
+		}
+	}
+}
+
+// value conversions
+
+func numeric(v openzwave.Value) (float64, bool) {
+	if f, ok := v.GetFloat(); ok {
+		return f, true
+	}
+	if u, ok := v.GetUint8(); ok {
+		return float64(u), true
+	}
+	if b, ok := v.GetBool(); ok {
+		if b {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func isOn(v openzwave.Value) (bool, bool) {
+	if b, ok := v.GetBool(); ok {
+		return b, true
+	}
+	if u, ok := v.GetUint8(); ok {
+		return u != 0, true
+	}
+	return false, false
+}
@@ -0,0 +1,138 @@
+// Provides a device adapter driven by declarative mappings between zwave values and ninja channels
+package generic
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ninjasphere/go-openzwave"
+)
+
+//
+// A Mapping describes how the values of a zwave product map to ninja
+// channels. Mappings are read from JSON files of the form:
+//
+//   [
+//     {
+//       "manufacturerId": "0086",
+//       "productId": "0005",
+//       "thingType": "sensor",
+//       "channels": [
+//         { "channel": "temperature", "commandClass": 49, "instance": 1, "index": 1, "poll": true },
+//         { "channel": "power", "commandClass": 50, "instance": 1, "index": 8 },
+//         { "channel": "energy", "commandClass": 50, "instance": 1, "index": 0, "scale": 1000 }
+//       ]
+//     }
+//   ]
+//
+type Mapping struct {
+	ManufacturerId string           `json:"manufacturerId"`
+	ProductId      string           `json:"productId"`
+	ThingType      string           `json:"thingType"`
+	Channels       []ChannelMapping `json:"channels"`
+}
+
+//
+// A ChannelMapping maps a single zwave value to a ninja channel. The
+// reported state is value * scale + offset, where an unspecified scale is 1.
+// The scale converts the unit of the zwave value to the unit of the ninja
+// channel, e.g. 1000 for a meter that reports kWh on an energy channel,
+// which reports Wh.
+//
+type ChannelMapping struct {
+	Channel      string  `json:"channel"`      // the kind of ninja channel, e.g. "temperature"
+	ID           string  `json:"id,omitempty"` // the channel id, defaults to the kind of channel
+	CommandClass uint8   `json:"commandClass"`
+	Instance     uint8   `json:"instance"`
+	Index        uint8   `json:"index"`
+	Scale        float64 `json:"scale,omitempty"`
+	Offset       float64 `json:"offset,omitempty"`
+	Poll         bool    `json:"poll,omitempty"`
+}
+
+func (mapping *Mapping) GetProductId() openzwave.ProductId {
+	return openzwave.ProductId{mapping.ManufacturerId, mapping.ProductId}
+}
+
+func (cm *ChannelMapping) ValueId() openzwave.ValueID {
+	return openzwave.ValueID{cm.CommandClass, cm.Instance, cm.Index}
+}
+
+func (cm *ChannelMapping) GetID() string {
+	if cm.ID != "" {
+		return cm.ID
+	}
+	return cm.Channel
+}
+
+func (cm *ChannelMapping) apply(raw float64) float64 {
+	scale := cm.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	return raw*scale + cm.Offset
+}
+
+func (mapping *Mapping) validate() error {
+	if mapping.ManufacturerId == "" || mapping.ProductId == "" {
+		return fmt.Errorf("mapping requires both manufacturerId and productId")
+	}
+	ids := make(map[string]bool)
+	for _, cm := range mapping.Channels {
+		if _, ok := kinds[cm.Channel]; !ok {
+			return fmt.Errorf("unsupported channel %q for product %v", cm.Channel, mapping.GetProductId())
+		}
+		if ids[cm.GetID()] {
+			return fmt.Errorf("duplicate channel id %q for product %v", cm.GetID(), mapping.GetProductId())
+		}
+		ids[cm.GetID()] = true
+	}
+	return nil
+}
+
+//
+// Load all the mappings found in the *.json files of the specified
+// directory. A missing directory yields no mappings.
+//
+func LoadMappings(dir string) ([]*Mapping, error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Unable to read device mappings from %s: %s", dir, err)
+	}
+
+	result := make([]*Mapping, 0)
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		path := filepath.Join(dir, file.Name())
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read device mappings from %s: %s", path, err)
+		}
+
+		mappings := make([]*Mapping, 0)
+		err = json.Unmarshal(buf, &mappings)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse device mappings from %s: %s", path, err)
+		}
+
+		for _, mapping := range mappings {
+			err = mapping.validate()
+			if err != nil {
+				return nil, fmt.Errorf("Invalid device mapping in %s: %s", path, err)
+			}
+		}
+
+		result = append(result, mappings...)
+	}
+
+	return result, nil
+}
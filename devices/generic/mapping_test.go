@@ -0,0 +1,89 @@
+package generic
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ninjasphere/go-openzwave"
+)
+
+func writeMappings(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "library")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestMappingsAreLoaded(t *testing.T) {
+	dir := writeMappings(t, map[string]string{
+		"aeon.json": `[{"manufacturerId": "0086", "productId": "0005", "channels": [
+			{"channel": "temperature", "commandClass": 49, "instance": 1, "index": 1, "poll": true},
+			{"channel": "energy", "commandClass": 50, "instance": 1, "index": 0, "scale": 1000}]}]`,
+		"README.txt": `not a mapping`,
+	})
+	defer os.RemoveAll(dir)
+
+	mappings, err := LoadMappings(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mappings) != 1 {
+		t.Fatalf("expected 1 mapping, got %d", len(mappings))
+	}
+	if id := mappings[0].GetProductId(); id != (openzwave.ProductId{"0086", "0005"}) {
+		t.Errorf("expected product 0086/0005, got %v", id)
+	}
+	channels := mappings[0].Channels
+	if len(channels) != 2 || channels[0].GetID() != "temperature" || !channels[0].Poll {
+		t.Errorf("expected a polled temperature channel first, got %+v", channels)
+	}
+}
+
+func TestMissingLibraryHasNoMappings(t *testing.T) {
+	mappings, err := LoadMappings(filepath.Join(os.TempDir(), "no-such-library"))
+	if err != nil || len(mappings) != 0 {
+		t.Errorf("expected no mappings and no error, got %v, %v", mappings, err)
+	}
+}
+
+func TestInvalidMappingsAreRejected(t *testing.T) {
+	invalid := map[string]string{
+		"no product":   `[{"manufacturerId": "0086", "channels": []}]`,
+		"unsupported":  `[{"manufacturerId": "0086", "productId": "0005", "channels": [{"channel": "smell", "commandClass": 49}]}]`,
+		"duplicate id": `[{"manufacturerId": "0086", "productId": "0005", "channels": [{"channel": "power", "commandClass": 50, "index": 8}, {"channel": "power", "commandClass": 49, "index": 4}]}]`,
+		"not json":     `[{"manufacturerId": `,
+	}
+	for name, content := range invalid {
+		dir := writeMappings(t, map[string]string{"mapping.json": content})
+		if _, err := LoadMappings(dir); err == nil {
+			t.Errorf("expected the %s mapping to be rejected", name)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestScaleAndOffsetAreApplied(t *testing.T) {
+	cases := []struct {
+		cm       ChannelMapping
+		raw      float64
+		expected float64
+	}{
+		{ChannelMapping{}, 12.5, 12.5},
+		{ChannelMapping{Scale: 1000}, 1.5, 1500},
+		{ChannelMapping{Offset: -2}, 21, 19},
+		{ChannelMapping{Scale: 0.1, Offset: 1}, 200, 21},
+	}
+	for _, c := range cases {
+		if actual := c.cm.apply(c.raw); actual != c.expected {
+			t.Errorf("expected %v with scale %v and offset %v, got %v", c.expected, c.cm.Scale, c.cm.Offset, actual)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package generic
+
+import (
+	"github.com/ninjasphere/go-openzwave"
+	"github.com/ninjasphere/go-openzwave/CC"
+)
+
+//
+// The values that are probed for on nodes of unknown products, in order
+// of precedence, and the channels they map to. The indices of multilevel
+// sensor values are the zwave sensor types.
+//
+var candidates = []ChannelMapping{
+	{Channel: "on-off", CommandClass: CC.SWITCH_BINARY, Instance: 1, Index: 0, Poll: true},
+	{Channel: "on-off", CommandClass: CC.SWITCH_MULTILEVEL, Instance: 1, Index: 0, Poll: true},
+	{Channel: "brightness", CommandClass: CC.SWITCH_MULTILEVEL, Instance: 1, Index: 0},
+	{Channel: "motion", CommandClass: CC.SENSOR_BINARY, Instance: 1, Index: 0},
+	{Channel: "temperature", CommandClass: CC.SENSOR_MULTILEVEL, Instance: 1, Index: 1},
+	{Channel: "illuminance", CommandClass: CC.SENSOR_MULTILEVEL, Instance: 1, Index: 3},
+	{Channel: "humidity", CommandClass: CC.SENSOR_MULTILEVEL, Instance: 1, Index: 5},
+	{Channel: "power", CommandClass: CC.METER, Instance: 1, Index: 8, Poll: true},
+	{Channel: "power", CommandClass: CC.SENSOR_MULTILEVEL, Instance: 1, Index: 4},
+	{Channel: "energy", CommandClass: CC.METER, Instance: 1, Index: 0, Scale: 1000, Poll: true}, // kWh to Wh
+	{Channel: "battery", CommandClass: CC.BATTERY, Instance: 1, Index: 0},
+}
+
+//
+// Derive a mapping for a node from the command class values it supports.
+// At most one value is mapped to each kind of channel.
+//
+func Probe(node openzwave.Node) *Mapping {
+	productId := node.GetProductId()
+
+	mapping := &Mapping{
+		ManufacturerId: productId.ManufacturerId,
+		ProductId:      productId.ProductId,
+		ThingType:      "sensor",
+		Channels:       make([]ChannelMapping, 0),
+	}
+
+	mapped := make(map[string]bool)
+	for _, candidate := range candidates {
+		if mapped[candidate.Channel] || !Supports(node, candidate.ValueId()) {
+			continue
+		}
+		mapping.Channels = append(mapping.Channels, candidate)
+		mapped[candidate.Channel] = true
+	}
+
+	if mapped["brightness"] {
+		mapping.ThingType = "light"
+	} else if mapped["on-off"] {
+		mapping.ThingType = "socket"
+	}
+
+	return mapping
+}
+
+//
+// Answer true if the node has a readable value with the specified id.
+//
+func Supports(node openzwave.Node, id openzwave.ValueID) bool {
+	v := node.GetValueWithId(id)
+	if v == nil {
+		return false
+	}
+	if _, ok := numeric(v); ok {
+		return true
+	}
+	_, ok := v.GetString()
+	return ok
+}
@@ -0,0 +1,99 @@
+package generic
+
+import (
+	"testing"
+
+	"github.com/ninjasphere/go-openzwave"
+	"github.com/ninjasphere/go-openzwave/CC"
+
+	"github.com/ninjasphere/driver-go-zwave/fake"
+)
+
+var (
+	unknownProduct     = openzwave.ProductId{"0115", "0100"}
+	unknownDescription = openzwave.ProductDescription{"Z-Wave.Me", "Unknown", "Unknown"}
+)
+
+func newNode() *fake.Node {
+	return fake.NewAPI(nil, nil).NewNode(2, unknownProduct, unknownDescription)
+}
+
+func channelIds(mapping *Mapping) []string {
+	ids := make([]string, 0)
+	for _, cm := range mapping.Channels {
+		ids = append(ids, cm.GetID())
+	}
+	return ids
+}
+
+func TestProbeMapsDimmerToLight(t *testing.T) {
+	node := newNode()
+	node.AddValue(openzwave.ValueID{CC.SWITCH_MULTILEVEL, 1, 0}, uint8(0))
+
+	mapping := Probe(node)
+	if mapping.ThingType != "light" {
+		t.Errorf("expected a light, got %s", mapping.ThingType)
+	}
+	if ids := channelIds(mapping); len(ids) != 2 || ids[0] != "on-off" || ids[1] != "brightness" {
+		t.Errorf("expected on-off and brightness channels, got %v", ids)
+	}
+	if product := mapping.GetProductId(); product != unknownProduct {
+		t.Errorf("expected the mapping to be for %v, got %v", unknownProduct, product)
+	}
+}
+
+func TestProbeMapsEachChannelOnce(t *testing.T) {
+	node := newNode()
+	node.AddValue(openzwave.ValueID{CC.SWITCH_BINARY, 1, 0}, false)
+	node.AddValue(openzwave.ValueID{CC.SENSOR_MULTILEVEL, 1, 4}, 12.0)
+	node.AddValue(openzwave.ValueID{CC.METER, 1, 8}, 11.5)
+	node.AddValue(openzwave.ValueID{CC.METER, 1, 0}, 2.5)
+
+	mapping := Probe(node)
+	if mapping.ThingType != "socket" {
+		t.Errorf("expected a socket, got %s", mapping.ThingType)
+	}
+	ids := channelIds(mapping)
+	if len(ids) != 3 || ids[0] != "on-off" || ids[1] != "power" || ids[2] != "energy" {
+		t.Fatalf("expected on-off, power and energy channels, got %v", ids)
+	}
+	if power := mapping.Channels[1]; power.CommandClass != CC.METER {
+		t.Errorf("expected power to be mapped from the meter, got command class %d", power.CommandClass)
+	}
+	if energy := mapping.Channels[2]; energy.apply(2.5) != 2500 {
+		t.Errorf("expected energy to be reported in Wh, got %v", energy.apply(2.5))
+	}
+}
+
+func TestProbeMapsSensors(t *testing.T) {
+	node := newNode()
+	node.AddValue(openzwave.ValueID{CC.SENSOR_BINARY, 1, 0}, false)
+	node.AddValue(openzwave.ValueID{CC.SENSOR_MULTILEVEL, 1, 3}, uint8(30))
+	node.AddValue(openzwave.ValueID{CC.BATTERY, 1, 0}, uint8(100))
+
+	mapping := Probe(node)
+	if mapping.ThingType != "sensor" {
+		t.Errorf("expected a sensor, got %s", mapping.ThingType)
+	}
+	if ids := channelIds(mapping); len(ids) != 3 || ids[0] != "motion" || ids[1] != "illuminance" || ids[2] != "battery" {
+		t.Errorf("expected motion, illuminance and battery channels, got %v", ids)
+	}
+}
+
+func TestProbeFindsNothingOnEmptyNode(t *testing.T) {
+	if mapping := Probe(newNode()); len(mapping.Channels) != 0 {
+		t.Errorf("expected no channels, got %v", channelIds(mapping))
+	}
+}
+
+func TestSupportsRequiresValue(t *testing.T) {
+	node := newNode()
+	node.AddValue(openzwave.ValueID{CC.SENSOR_MULTILEVEL, 1, 1}, 21.0)
+
+	if !Supports(node, openzwave.ValueID{CC.SENSOR_MULTILEVEL, 1, 1}) {
+		t.Error("expected the temperature value to be supported")
+	}
+	if Supports(node, openzwave.ValueID{CC.SENSOR_MULTILEVEL, 1, 5}) {
+		t.Error("expected a missing value not to be supported")
+	}
+}
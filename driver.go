@@ -2,13 +2,20 @@ package main
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/ninjasphere/go-ninja/api"
+	"github.com/ninjasphere/go-ninja/bus"
 	"github.com/ninjasphere/go-ninja/logger"
 	"github.com/ninjasphere/go-ninja/support"
 
 	"github.com/ninjasphere/go-openzwave"
 	"github.com/ninjasphere/go-openzwave/NT"
+
+	"github.com/ninjasphere/driver-go-zwave/devices/generic"
+	"github.com/ninjasphere/driver-go-zwave/spi"
+	"github.com/ninjasphere/driver-go-zwave/trace"
+	"github.com/ninjasphere/driver-go-zwave/utils"
 )
 
 const (
@@ -21,44 +28,133 @@ var (
 
 type ZDriver struct {
 	support.DriverSupport
-	config   *Zconfig
-	debug    bool
-	zwaveAPI openzwave.API
-	exit     chan int
+	config    *Zconfig
+	debug     bool
+	zwaveAPI  openzwave.API
+	exit      chan int
+	mutex     sync.Mutex               // guards zwaveAPI, devices, byNode, config, config.Devices and config.Reporting
+	saving    sync.Mutex               // serializes saveConfig, so the last configuration saved is the latest
+	devices   map[string]spi.Patchable // devices previously exported, by natural id
+	byNode    map[uint8]spi.Patchable  // the device of each node, by node id
+	names     *spi.Names
+	batteries *spi.Batteries
+	pairing   pairing
+	healing   healing
+	lastSeen  lastSeenCheck
+	clock     utils.Clock // the system clock, unless under test
+
+	conn     spi.Connection  // the connection devices are exported to
+	record   string          // if not empty, the trace file notifications are recorded to
+	recorder *trace.Recorder // records notifications to the trace file, closed by Stop
+	replay   string          // if not empty, the trace file replayed instead of running OpenZWave
+	realtime bool            // if true, the replay preserves the recorded timing
 }
 
-type Zconfig struct {
+func (driver *ZDriver) ZWave() openzwave.API {
+	driver.mutex.Lock()
+	defer driver.mutex.Unlock()
+	return driver.zwaveAPI
 }
 
-func defaultConfig() *Zconfig {
-	return &Zconfig{}
+//
+// Record the API that OpenZWave calls the driver back with. The first time,
+// warn about the controller and heal commands that this version of
+// go-openzwave does not expose, since the methods that need them are
+// disabled, as are scheduled heals.
+//
+func (driver *ZDriver) setAPI(api openzwave.API) {
+	driver.mutex.Lock()
+	first := driver.zwaveAPI == nil
+	driver.zwaveAPI = api
+	driver.mutex.Unlock()
+
+	if !first {
+		return
+	}
+	if _, ok := spi.GetController(api); !ok {
+		driver.Log.Warningf("Inclusion and exclusion are disabled - this version of go-openzwave does not expose controller commands")
+	}
+	if _, ok := spi.GetHealer(api); !ok {
+		driver.Log.Warningf("Network heals are disabled - this version of go-openzwave does not expose heal commands")
+		driver.cancelHealSchedule()
+	}
 }
 
-func (driver *ZDriver) ZWave() openzwave.API {
-	return driver.zwaveAPI
+//
+// Answer the current configuration. The configuration is replaced, not
+// modified, by Start, so its settings may be read without the lock once
+// it has been answered; its devices and reporting policies may not.
+//
+func (driver *ZDriver) configuration() *Zconfig {
+	driver.mutex.Lock()
+	defer driver.mutex.Unlock()
+	return driver.config
 }
 
 func (driver *ZDriver) Ninja() ninja.Driver {
 	return driver
 }
 
-func (driver *ZDriver) Connection() *ninja.Connection {
-	return driver.Conn
+func (driver *ZDriver) Connection() spi.Connection {
+	return driver.conn
 }
 
-func newZWaveDriver(debug bool) (*ZDriver, error) {
+func (driver *ZDriver) Names() *spi.Names {
+	return driver.names
+}
+
+func (driver *ZDriver) Batteries() *spi.Batteries {
+	return driver.batteries
+}
+
+func (driver *ZDriver) DeviceConfig(naturalID string) *spi.DeviceConfig {
+	driver.mutex.Lock()
+	defer driver.mutex.Unlock()
+	return driver.config.Devices[naturalID]
+}
+
+func (driver *ZDriver) UpdateDeviceConfig(naturalID string, update func(config *spi.DeviceConfig)) error {
+	driver.mutex.Lock()
+	if driver.config.Devices == nil {
+		driver.config.Devices = make(map[string]*spi.DeviceConfig)
+	}
+	driver.config.Devices[naturalID] = driver.config.Devices[naturalID].Update(update)
+	driver.mutex.Unlock()
+
+	driver.saveConfig()
+	return nil
+}
+
+func (driver *ZDriver) Clock() utils.Clock {
+	return driver.clock
+}
+
+func (driver *ZDriver) ReportingPolicy(channel string) *spi.ReportingPolicy {
+	driver.mutex.Lock()
+	defer driver.mutex.Unlock()
+	return driver.config.Reporting[channel]
+}
+
+func newZWaveDriver(debug bool, record string) (*ZDriver, error) {
 
 	driver := &ZDriver{
 		config:   defaultConfig(),
 		debug:    debug,
 		zwaveAPI: nil,
 		exit:     make(chan int, 0),
+		devices:  make(map[string]spi.Patchable),
+		byNode:   make(map[uint8]spi.Patchable),
+		record:   record,
+		clock:    utils.SystemClock,
 	}
 
 	err := driver.Init(info)
 	if err != nil {
 		return nil, err
 	}
+	driver.conn = driver.Conn
+
+	driver.watchReconnects(driver.Conn)
 
 	err = driver.Export(driver)
 	if err != nil {
@@ -71,56 +167,101 @@ func newZWaveDriver(debug bool) (*ZDriver, error) {
 func (d *ZDriver) Start(config *Zconfig) error {
 	d.Log.Infof("Driver %s starting with config %v", driverName, config)
 
+	if config == nil {
+		config = defaultConfig()
+	}
+	config.applyDefaults()
+	err := config.validate()
+	if err != nil {
+		d.Log.Errorf("Invalid configuration: %s", err)
+		return err
+	}
+
+	d.mutex.Lock()
 	d.config = config
+	d.mutex.Unlock()
 
-	zwaveDeviceFactory := func(api openzwave.API, node openzwave.Node) openzwave.Device {
-		d.zwaveAPI = api
-		return GetLibrary().GetDeviceFactory(*node.GetProductId())(d, node)
-	}
-
-	shuttingDown := false
-
-	notificationCallback := func(api openzwave.API, nt openzwave.Notification) {
-		switch nt.GetNotificationType().Code {
-		case NT.NODE_REMOVED:
-			//
-			// Currently the RPC layer prevents us releasing the resources associated
-			// with removed nodes. If the nodes come back (when, say, the zwave controller
-			// is re-inserted), we can't build new device  wrappers for them because the
-			// devices are already registered with the RPC layer.
-			//
-			// We could fix the RPC layer or we could attempt to work around the
-			// problems with the RPC layer by using "patch" proxies for each ninja device
-			// that allows us to change the actual zwave device.
-			//
-			// For now, it is simpler if we simply restart the driver process in the event of node
-			// removal. This also avoids potential race conditions between
-			// event dispatch and freeing of the resources associated with the
-			// removed node.
-			//
-			if !shuttingDown {
-				shuttingDown = true
-				api.Logger().Infof("ZWave driver shutdown in response to node removed event.")
-				api.Shutdown(openzwave.EXIT_NODE_REMOVED)
-			}
-		default:
+	if config.NetworkKey == "" {
+		d.Log.Infof("No networkKey configured - secure devices, such as door locks, cannot be included")
+	}
+
+	backendLog := logger.GetLogger(fmt.Sprintf("%s.backend", d.Info.ID))
+	if !d.debug {
+		level := config.logLevel()
+		backendLog.SetLogLevel(level)
+		d.Log.SetLogLevel(level)
+	}
+
+	if d.replay == "" {
+		names, err := spi.LoadNames(config.namesPath())
+		if err != nil {
+			return err
+		}
+		d.names = names
+
+		err = d.preserveNames(config.UserDataDir)
+		if err != nil {
+			return err
+		}
 
+		batteries, err := spi.LoadBatteries(config.batteriesPath(), config.LowBattery)
+		if err != nil {
+			return err
 		}
+		d.batteries = batteries
+	} else {
+		d.names = spi.NewNames()
+		d.batteries = spi.NewBatteries(config.LowBattery)
 	}
 
-	configurator := openzwave.
-		BuildAPI("/usr/local/etc/openzwave", ".", "").
-		SetLogger(logger.GetLogger(fmt.Sprintf("%s.backend", d.Info.ID))).
-		SetNotificationCallback(notificationCallback).
-		SetDeviceFactory(zwaveDeviceFactory)
+	err = d.loadLibrary()
+	if err != nil {
+		return err
+	}
+
+	var callback openzwave.NotificationCallback = d.notified
 
 	if d.debug {
-		callback := func(api openzwave.API, notification openzwave.Notification) {
+		callback = func(api openzwave.API, notification openzwave.Notification) {
 			api.Logger().Infof("%v\n", notification)
-			notificationCallback(api, notification)
+			d.notified(api, notification)
+		}
+	}
+
+	if d.record != "" {
+		recorder, err := trace.NewRecorder(d.record)
+		if err != nil {
+			d.Log.Errorf("Failed to open trace file %s: %s", d.record, err)
+			return err
+		}
+		d.recorder = recorder
+		next := callback
+		callback = func(api openzwave.API, notification openzwave.Notification) {
+			if err := recorder.Record(notification); err != nil {
+				api.Logger().Warningf("Failed to record notification: %s", err)
+			}
+			next(api, notification)
 		}
+	}
 
-		configurator.SetNotificationCallback(callback)
+	if d.replay != "" {
+		return d.startReplay(callback)
+	}
+
+	configurator := openzwave.
+		BuildAPI(config.ConfigDir, config.UserDataDir, config.options()).
+		SetLogger(backendLog).
+		SetNotificationCallback(callback).
+		SetDeviceFactory(d.newDevice)
+
+	if config.ControllerDevice != "" {
+		dc, ok := configurator.(deviceConfigurator)
+		if !ok {
+			err := fmt.Errorf("Unable to use controllerDevice %s - not supported by this version of go-openzwave", config.ControllerDevice)
+			d.Log.Errorf("%s", err)
+			return err
+		}
+		dc.SetDevice(config.ControllerDevice)
 	}
 
 	go func() {
@@ -129,14 +270,344 @@ func (d *ZDriver) Start(config *Zconfig) error {
 		d.exit <- configurator.Run()
 	}()
 
-	d.SendEvent("config", config)
+	d.scheduleHeal()
+
+	d.saveConfig()
 
 	return nil
 }
 
+//
+// Build the device for a node, or patch the node into the device built for
+// an earlier incarnation of the node.
+//
+func (d *ZDriver) newDevice(api openzwave.API, node openzwave.Node) openzwave.Device {
+	d.setAPI(api)
+	return d.patch(GetLibrary().GetDeviceFactory(*node.GetProductId())(d, node), node)
+}
+
+//
+// Called by OpenZWave for every notification.
+//
+func (d *ZDriver) notified(api openzwave.API, nt openzwave.Notification) {
+	d.setAPI(api)
+	switch nt.GetNotificationType().Code {
+	case NT.NODE_ADDED:
+		d.pairingNodeChanged(inclusion, "node-added", nt.GetNode().GetId())
+	case NT.NODE_REMOVED:
+		//
+		// The RPC layer prevents us releasing the resources associated
+		// with removed nodes, so the device wrapper has detached itself
+		// from the node and stays registered with the RPC layer. If the node
+		// comes back (when, say, the zwave controller is re-inserted), the
+		// new node is patched into the existing device by the device factory.
+		//
+		api.Logger().Infof("Node %v removed from the network.", nt.GetNode())
+		d.forget(nt.GetNode())
+		if d.pairingNodeChanged(exclusion, "node-removed", nt.GetNode().GetId()) {
+			d.release(nt.GetNode())
+		}
+	case NT.NOTIFICATION:
+		code, ok := spi.NotificationCode(nt)
+		if !ok {
+			d.notificationCodesUnavailable()
+			break
+		}
+		device := d.deviceOf(nt.GetNode())
+		if device == nil {
+			break
+		}
+		switch code {
+		case spi.CODE_MSG_COMPLETE, spi.CODE_NO_OPERATION:
+			device.Seen()
+		case spi.CODE_AWAKE:
+			device.Seen()
+			go device.WakeUp()
+		case spi.CODE_TIMEOUT:
+			device.CommandFailed()
+		case spi.CODE_DEAD:
+			device.SetOnline(false)
+		case spi.CODE_ALIVE:
+			device.SetOnline(true)
+		}
+	case NT.VALUE_CHANGED, NT.VALUE_REFRESHED, NT.NODE_EVENT:
+		device := d.deviceOf(nt.GetNode())
+		if device == nil {
+			break
+		}
+		device.Seen()
+		// a sleeping node that reports a value is awake
+		if nt.GetNotificationType().Code == NT.VALUE_CHANGED && device.Sleeps() {
+			go device.Heard()
+		}
+	default:
+
+	}
+}
+
+//
+// Add the declarative device mappings found in the library directory
+// to the library of device factories.
+//
+func (d *ZDriver) loadLibrary() error {
+	mappings, err := generic.LoadMappings(d.configuration().LibraryDir)
+	if err != nil {
+		d.Log.Errorf("Invalid device library: %s", err)
+		return err
+	}
+
+	library := GetLibrary()
+	for _, mapping := range mappings {
+		if library.AddMapping(mapping) {
+			d.Log.Infof("Device mapping for %v replaces the built-in device factory", mapping.GetProductId())
+		}
+	}
+	return nil
+}
+
+//
+// Implemented by the MQTT client of a *ninja.Connection, and by connections
+// that report (re)connections to the broker themselves.
+//
+type connectNotifier interface {
+	OnConnect(callback func())
+}
+
+//
+// Implemented by *ninja.Connection.
+//
+type mqttConnection interface {
+	GetMqttClient() bus.Bus
+}
+
+//
+// Republish the last known values whenever the connection reconnects to
+// the broker, if it reports reconnections. The connection is established
+// before the driver watches it, so every connection reported is a
+// reconnection.
+//
+func (d *ZDriver) watchReconnects(conn interface{}) {
+	var source interface{} = conn
+	if mqtt, ok := conn.(mqttConnection); ok {
+		source = mqtt.GetMqttClient()
+	}
+	if notifier, ok := source.(connectNotifier); ok {
+		notifier.OnConnect(d.republish)
+	} else {
+		d.Log.Warningf("Connection does not report reconnections - last known values are only republished by heartbeats or the republish method")
+	}
+}
+
+//
+// Send the last known value of every channel of every device again, so that
+// clients that connected after the values were reported can see them.
+//
+func (d *ZDriver) Republish() error {
+	d.republish()
+	return nil
+}
+
+//
+// Called when the connection to the broker is re-established.
+//
+func (d *ZDriver) republish() {
+	devices := d.allDevices()
+	d.Log.Infof("Republishing the last known values of %d devices", len(devices))
+	for _, device := range devices {
+		device.Republish()
+	}
+}
+
+//
+// Answer every device exported so far.
+//
+func (d *ZDriver) allDevices() []spi.Patchable {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	devices := make([]spi.Patchable, 0, len(d.devices))
+	for _, device := range d.devices {
+		devices = append(devices, device)
+	}
+	return devices
+}
+
+//
+// Implemented by configurators that allow the controller device to be
+// specified, rather than discovered.
+//
+type deviceConfigurator interface {
+	SetDevice(device string) openzwave.Configurator
+}
+
+//
+// Persist the current configuration by sending it back to ninja. The
+// configuration sent is a snapshot, since devices may update their
+// configuration while it is serialized.
+//
+func (d *ZDriver) saveConfig() {
+	d.saving.Lock()
+	defer d.saving.Unlock()
+
+	d.mutex.Lock()
+	snapshot := d.config.snapshot()
+	d.mutex.Unlock()
+
+	err := d.SendEvent("config", snapshot)
+	if err != nil {
+		d.Log.Warningf("Failed to save configuration: %s", err)
+	}
+}
+
+//
+// If a device with the same identity as the newly built device has
+// been exported previously, patch the node into the existing device and
+// discard the new one, since the RPC layer won't let us export it again.
+//
+func (d *ZDriver) patch(device openzwave.Device, node openzwave.Node) openzwave.Device {
+	patchable, ok := device.(spi.Patchable)
+	if !ok {
+		return device
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := patchable.GetDeviceInfo().NaturalID
+	if id == "" {
+		// the device could not be named, so it is never exported
+		return device
+	}
+	existing, ok := d.devices[id]
+	if ok {
+		existing.Patch(node)
+		d.byNode[node.GetId()] = existing
+		return existing
+	}
+
+	d.devices[id] = patchable
+	d.byNode[node.GetId()] = patchable
+	return patchable
+}
+
+//
+// Forget the device of a removed node, so that notifications and heals no
+// longer reach it. The device stays registered by natural id, ready to be
+// patched if the node comes back.
+//
+func (d *ZDriver) forget(node openzwave.Node) {
+	if node == nil {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.byNode, node.GetId())
+}
+
+//
+// Let the next node of the same product to be included take over the
+// natural id of an excluded node.
+//
+func (d *ZDriver) release(node openzwave.Node) {
+	if node == nil || d.names == nil {
+		return
+	}
+	if err := d.names.Release(spi.LegacyNaturalID(node)); err != nil {
+		d.Log.Warningf("Failed to release the natural id of node %v: %s", node, err)
+	}
+}
+
+//
+// Answer the device of the node, or nil if the node has no device.
+//
+func (d *ZDriver) deviceOf(node openzwave.Node) spi.Patchable {
+	if node == nil {
+		return nil
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.byNode[node.GetId()]
+}
+
+//
+// Answer the commands waiting for each sleeping device to wake up, and when
+// each last woke up, by natural id.
+//
+func (d *ZDriver) GetQueues() (map[string]*spi.QueueState, error) {
+	d.mutex.Lock()
+	devices := make(map[string]spi.Patchable, len(d.devices))
+	for id, device := range d.devices {
+		devices[id] = device
+	}
+	d.mutex.Unlock()
+
+	queues := make(map[string]*spi.QueueState)
+	for id, device := range devices {
+		if device.Sleeps() {
+			queues[id] = device.QueueState()
+		}
+	}
+	return queues, nil
+}
+
+//
+// Answer the health of each device's node, by natural id.
+//
+func (d *ZDriver) GetHealth() (map[string]*spi.HealthState, error) {
+	d.mutex.Lock()
+	devices := make(map[string]spi.Patchable, len(d.devices))
+	for id, device := range d.devices {
+		devices[id] = device
+	}
+	d.mutex.Unlock()
+
+	health := make(map[string]*spi.HealthState)
+	for id, device := range devices {
+		health[id] = device.Health()
+	}
+	return health, nil
+}
+
+type NaturalIDAssignment struct {
+	LegacyNaturalID string `json:"legacyNaturalId"`
+	NaturalID       string `json:"naturalId"`
+}
+
+//
+// Answer the mapping from v0 natural ids to v1 natural ids, so that
+// clients holding v0 ids can migrate them.
+//
+func (d *ZDriver) GetNaturalIDs() (map[string]string, error) {
+	if d.names == nil {
+		return nil, fmt.Errorf("Driver has not been started")
+	}
+	return d.names.Mapping(), nil
+}
+
+//
+// Reassign a v1 natural id to a v0 natural id, typically because the node
+// has been re-included in the network with a different node id. The new
+// assignment takes effect the next time the node is added.
+//
+func (d *ZDriver) SetNaturalID(assignment NaturalIDAssignment) error {
+	if d.names == nil {
+		return fmt.Errorf("Driver has not been started")
+	}
+	if assignment.LegacyNaturalID == "" || assignment.NaturalID == "" {
+		return fmt.Errorf("Both legacyNaturalId and naturalId are required")
+	}
+	return d.names.Assign(assignment.LegacyNaturalID, assignment.NaturalID)
+}
+
 func (d *ZDriver) Stop() error {
 	d.Log.Infof("Stop received - shutting down")
-	d.zwaveAPI.Shutdown(0)
+	d.cancelHealSchedule()
+	d.stopLastSeenChecks()
+	d.ZWave().Shutdown(0)
+	if d.recorder != nil {
+		if err := d.recorder.Close(); err != nil {
+			d.Log.Warningf("Failed to close trace file %s: %s", d.record, err)
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ninjasphere/go-ninja/logger"
+	"github.com/ninjasphere/go-openzwave"
+	"github.com/ninjasphere/go-openzwave/CC"
+
+	"github.com/ninjasphere/driver-go-zwave/fake"
+	"github.com/ninjasphere/driver-go-zwave/spi"
+	"github.com/ninjasphere/driver-go-zwave/utils"
+)
+
+var (
+	switchProduct     = openzwave.ProductId{"0086", "0003"}
+	switchDescription = openzwave.ProductDescription{"Aeon Labs", "Smart Energy Switch", "Switch"}
+)
+
+type driverEvent struct {
+	name    string
+	payload interface{}
+}
+
+//
+// testDriver is a driver whose OpenZWave API and ninja connection are fakes.
+// The events sent by the driver are collected in events.
+//
+type testDriver struct {
+	*ZDriver
+	api    *fake.API
+	conn   *fake.Connection
+	events chan driverEvent
+}
+
+func newTestDriver() *testDriver {
+	d := &ZDriver{
+		config:  defaultConfig(),
+		exit:    make(chan int, 1),
+		devices: make(map[string]spi.Patchable),
+		byNode:  make(map[uint8]spi.Patchable),
+		clock:   utils.SystemClock,
+	}
+	d.Info = info
+	d.Log = logger.GetLogger(driverName)
+	d.names = spi.NewNames()
+	d.batteries = spi.NewBatteries(d.config.LowBattery)
+
+	td := &testDriver{
+		ZDriver: d,
+		conn:    fake.NewConnection(),
+		events:  make(chan driverEvent, 256),
+	}
+	d.conn = td.conn
+	d.SetEventHandler(func(event string, payload interface{}) error {
+		td.events <- driverEvent{event, payload}
+		return nil
+	})
+
+	td.api = fake.NewAPI(d.newDevice, d.notified)
+	d.zwaveAPI = td.api
+	return td
+}
+
+//
+// Create a binary switch node, which is not yet on the network.
+//
+func (td *testDriver) newSwitch(id uint8) *fake.Node {
+	node := td.api.NewNode(id, switchProduct, switchDescription)
+	node.AddValue(openzwave.ValueID{CC.SWITCH_BINARY, 1, 0}, false)
+	return node
+}
+
+//
+// Wait for the driver to send the named event with the specified state,
+// skipping other events.
+//
+func (td *testDriver) expect(t *testing.T, name string, state string) driverEvent {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-td.events:
+			if event.name == name && stateOf(event.payload) == state {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s event with state %s", name, state)
+		}
+	}
+}
+
+func stateOf(payload interface{}) string {
+	switch event := payload.(type) {
+	case *PairingEvent:
+		return event.State
+	case *HealEvent:
+		return event.State
+	}
+	return ""
+}
+
+func TestReaddedNodeIsPatched(t *testing.T) {
+	td := newTestDriver()
+
+	first := td.api.Join(td.newSwitch(2)).(spi.Patchable)
+	id := first.GetDeviceInfo().NaturalID
+
+	td.api.Leave(2)
+	if nodes := td.nodeIds(); len(nodes) != 0 {
+		t.Fatalf("expected the removed node to be forgotten, got %v", nodes)
+	}
+
+	second := td.api.Join(td.newSwitch(2)).(spi.Patchable)
+	if second != first {
+		t.Error("expected the re-added node to be patched into the existing device")
+	}
+	if next := second.GetDeviceInfo().NaturalID; next != id {
+		t.Errorf("expected natural id %s after the node was re-added, got %s", id, next)
+	}
+	if devices := td.conn.Devices(); len(devices) != 1 {
+		t.Errorf("expected the device to be exported once, got %d devices", len(devices))
+	}
+	if nodes := td.nodeIds(); len(nodes) != 1 || nodes[0] != 2 {
+		t.Errorf("expected the device to be reachable by node id again, got %v", nodes)
+	}
+}
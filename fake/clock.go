@@ -0,0 +1,99 @@
+package fake
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ninjasphere/driver-go-zwave/utils"
+)
+
+//
+// Clock is a utils.Clock whose time only changes when the test says so.
+// Functions scheduled with AfterFunc are called synchronously by Advance,
+// in the order they fall due.
+//
+type Clock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*timer
+}
+
+var _ utils.Clock = (*Clock)(nil)
+
+type timer struct {
+	clock *Clock
+	when  time.Time
+	f     func()
+}
+
+func NewClock() *Clock {
+	return &Clock{now: time.Date(2014, time.September, 25, 14, 58, 0, 0, time.UTC)}
+}
+
+func (clock *Clock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.now
+}
+
+func (clock *Clock) AfterFunc(d time.Duration, f func()) utils.Timer {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	t := &timer{clock, clock.now.Add(d), f}
+
+	// keep the timers in the order they fall due
+	i := len(clock.timers)
+	for i > 0 && clock.timers[i-1].when.After(t.when) {
+		i--
+	}
+	clock.timers = append(clock.timers, nil)
+	copy(clock.timers[i+1:], clock.timers[i:])
+	clock.timers[i] = t
+	return t
+}
+
+func (t *timer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	for i, pending := range t.clock.timers {
+		if pending == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+//
+// Answer the number of scheduled functions that have not yet been called.
+//
+func (clock *Clock) Pending() int {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return len(clock.timers)
+}
+
+//
+// Move the clock forward by the specified duration, calling the functions
+// that fall due on the way with the clock set to the time they fall due.
+//
+func (clock *Clock) Advance(d time.Duration) {
+	clock.mutex.Lock()
+	end := clock.now.Add(d)
+	clock.mutex.Unlock()
+
+	for {
+		clock.mutex.Lock()
+		if len(clock.timers) == 0 || clock.timers[0].when.After(end) {
+			clock.now = end
+			clock.mutex.Unlock()
+			return
+		}
+		next := clock.timers[0]
+		clock.timers = clock.timers[1:]
+		clock.now = next.when
+		clock.mutex.Unlock()
+
+		next.f()
+	}
+}
@@ -0,0 +1,103 @@
+package fake
+
+import (
+	"sync"
+
+	"github.com/ninjasphere/go-ninja/api"
+	"github.com/ninjasphere/go-openzwave"
+
+	"github.com/ninjasphere/driver-go-zwave/spi"
+	"github.com/ninjasphere/driver-go-zwave/utils"
+)
+
+const (
+	DefaultLowBattery = 20 // the low battery threshold of fake drivers, in percent
+)
+
+//
+// Driver is an implementation of spi.Driver for device adapters under test.
+// Devices and channels are exported to a fake connection. Natural ids are
+// not persisted and devices have no configuration overrides unless they are
+// added to Devices, nor reporting policies unless they are added to Reporting.
+// Devices use the system clock unless the driver is given another with
+// SetClock before the devices are built.
+//
+type Driver struct {
+	API     *API
+	Conn    *Connection
+	Driver  ninja.Driver
+	Devices map[string]*spi.DeviceConfig // guarded by mutex once devices are running
+
+	Reporting map[string]*spi.ReportingPolicy
+
+	mutex     sync.Mutex
+	names     *spi.Names
+	batteries *spi.Batteries
+	clock     utils.Clock
+}
+
+var _ spi.Driver = (*Driver)(nil)
+
+//
+// Create a driver and a fake API whose device factory builds devices for
+// the driver with the specified factory.
+//
+func NewDriver(factory func(spi.Driver, openzwave.Node) openzwave.Device) *Driver {
+	driver := &Driver{
+		Conn:      NewConnection(),
+		Devices:   make(map[string]*spi.DeviceConfig),
+		Reporting: make(map[string]*spi.ReportingPolicy),
+		names:     spi.NewNames(),
+		batteries: spi.NewBatteries(DefaultLowBattery),
+		clock:     utils.SystemClock,
+	}
+	driver.API = NewAPI(func(api openzwave.API, node openzwave.Node) openzwave.Device {
+		return factory(driver, node)
+	}, nil)
+	return driver
+}
+
+func (driver *Driver) ZWave() openzwave.API {
+	return driver.API
+}
+
+func (driver *Driver) Ninja() ninja.Driver {
+	return driver.Driver
+}
+
+func (driver *Driver) Connection() spi.Connection {
+	return driver.Conn
+}
+
+func (driver *Driver) Names() *spi.Names {
+	return driver.names
+}
+
+func (driver *Driver) Batteries() *spi.Batteries {
+	return driver.batteries
+}
+
+func (driver *Driver) DeviceConfig(naturalID string) *spi.DeviceConfig {
+	driver.mutex.Lock()
+	defer driver.mutex.Unlock()
+	return driver.Devices[naturalID]
+}
+
+func (driver *Driver) UpdateDeviceConfig(naturalID string, update func(config *spi.DeviceConfig)) error {
+	driver.mutex.Lock()
+	defer driver.mutex.Unlock()
+	driver.Devices[naturalID] = driver.Devices[naturalID].Update(update)
+	return nil
+}
+
+func (driver *Driver) ReportingPolicy(channel string) *spi.ReportingPolicy {
+	return driver.Reporting[channel]
+}
+
+func (driver *Driver) Clock() utils.Clock {
+	return driver.clock
+}
+
+func (driver *Driver) SetClock(clock utils.Clock) {
+	driver.clock = clock
+}
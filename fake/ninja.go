@@ -0,0 +1,268 @@
+package fake
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/ninjasphere/go-ninja/api"
+
+	"github.com/ninjasphere/driver-go-zwave/spi"
+)
+
+//
+// Event is an event sent by an exported device or channel. Channel is
+// empty for events sent by a device.
+//
+type Event struct {
+	Device  ninja.Device
+	Channel string
+	Event   string
+	Payload interface{}
+}
+
+//
+// Connection is an in-process stand-in for the ninja RPC layer. It records
+// the devices and channels that are exported, and the events they send, and
+// lets tests call channel methods as the RPC layer would.
+//
+type Connection struct {
+	sync.Mutex
+	devices  []ninja.Device
+	channels map[ninja.Device]map[string]ninja.Channel
+	events   []Event
+	listener func(Event)
+
+	connected []func() // the callbacks registered with OnConnect
+}
+
+var _ spi.Connection = (*Connection)(nil)
+
+func NewConnection() *Connection {
+	return &Connection{
+		devices:  make([]ninja.Device, 0),
+		channels: make(map[ninja.Device]map[string]ninja.Channel),
+		events:   make([]Event, 0),
+	}
+}
+
+func (conn *Connection) ExportDevice(device ninja.Device) error {
+	conn.Lock()
+	defer conn.Unlock()
+
+	if _, ok := conn.channels[device]; ok {
+		return fmt.Errorf("device %s has already been exported", device.GetDeviceInfo().NaturalID)
+	}
+	conn.devices = append(conn.devices, device)
+	conn.channels[device] = make(map[string]ninja.Channel)
+	device.SetEventHandler(conn.recorder(device, ""))
+	return nil
+}
+
+func (conn *Connection) ExportChannel(device ninja.Device, channel ninja.Channel, id string) error {
+	conn.Lock()
+	defer conn.Unlock()
+
+	channels, ok := conn.channels[device]
+	if !ok {
+		return fmt.Errorf("device %s has not been exported", device.GetDeviceInfo().NaturalID)
+	}
+	if _, ok := channels[id]; ok {
+		return fmt.Errorf("channel %s of device %s has already been exported", id, device.GetDeviceInfo().NaturalID)
+	}
+	channels[id] = channel
+	channel.SetEventHandler(conn.recorder(device, id))
+	return nil
+}
+
+func (conn *Connection) recorder(device ninja.Device, channel string) func(event string, payload interface{}) error {
+	return func(event string, payload interface{}) error {
+		conn.Lock()
+		listener := conn.listener
+		conn.events = append(conn.events, Event{device, channel, event, payload})
+		conn.Unlock()
+
+		if listener != nil {
+			listener(Event{device, channel, event, payload})
+		}
+		return nil
+	}
+}
+
+//
+// Call the listener with each event as it is sent.
+//
+func (conn *Connection) SetListener(listener func(Event)) {
+	conn.Lock()
+	defer conn.Unlock()
+	conn.listener = listener
+}
+
+//
+// Call the callback whenever Reconnect is called, as the MQTT client of a
+// ninja connection does whenever it connects to the broker.
+//
+func (conn *Connection) OnConnect(callback func()) {
+	conn.Lock()
+	defer conn.Unlock()
+	conn.connected = append(conn.connected, callback)
+}
+
+//
+// Simulate the connection to the broker being re-established.
+//
+func (conn *Connection) Reconnect() {
+	conn.Lock()
+	callbacks := append([]func(){}, conn.connected...)
+	conn.Unlock()
+
+	for _, callback := range callbacks {
+		callback()
+	}
+}
+
+//
+// Answer the devices exported so far, in order.
+//
+func (conn *Connection) Devices() []ninja.Device {
+	conn.Lock()
+	defer conn.Unlock()
+	return append([]ninja.Device{}, conn.devices...)
+}
+
+//
+// Answer the channel exported with the specified id for the device, or nil.
+//
+func (conn *Connection) Channel(device ninja.Device, id string) ninja.Channel {
+	conn.Lock()
+	defer conn.Unlock()
+	return conn.channels[device][id]
+}
+
+//
+// Answer the events sent so far, in order.
+//
+func (conn *Connection) Events() []Event {
+	conn.Lock()
+	defer conn.Unlock()
+	return append([]Event{}, conn.events...)
+}
+
+//
+// Answer the payloads of the "state" events sent on the specified channel,
+// in order.
+//
+func (conn *Connection) States(channel string) []interface{} {
+	states := make([]interface{}, 0)
+	for _, event := range conn.Events() {
+		if event.Channel == channel && event.Event == "state" {
+			states = append(states, event.Payload)
+		}
+	}
+	return states
+}
+
+func (conn *Connection) ClearEvents() {
+	conn.Lock()
+	defer conn.Unlock()
+	conn.events = make([]Event, 0)
+}
+
+//
+// Call a method of an exported channel, as the RPC layer would. The method
+// name is the JSON-RPC method name (e.g. "turnOn") and each parameter is
+// decoded into the type of the corresponding argument of the method.
+//
+func (conn *Connection) Call(device ninja.Device, channelId string, method string, params []json.RawMessage) error {
+	channel := conn.Channel(device, channelId)
+	if channel == nil {
+		return fmt.Errorf("channel %s has not been exported", channelId)
+	}
+
+	name := []rune(method)
+	name[0] = unicode.ToUpper(name[0])
+	fn := reflect.ValueOf(channel).MethodByName(string(name))
+	if !fn.IsValid() {
+		return fmt.Errorf("channel %s has no method %s", channelId, method)
+	}
+
+	if fn.Type().NumIn() != len(params) {
+		return fmt.Errorf("method %s of channel %s expects %d parameters, got %d", method, channelId, fn.Type().NumIn(), len(params))
+	}
+	args := make([]reflect.Value, len(params))
+	for i, param := range params {
+		arg := reflect.New(fn.Type().In(i))
+		err := json.Unmarshal(param, arg.Interface())
+		if err != nil {
+			return fmt.Errorf("parameter %d of method %s: %s", i, method, err)
+		}
+		args[i] = arg.Elem()
+	}
+
+	results := fn.Call(args)
+	if len(results) > 0 {
+		if err, ok := results[len(results)-1].Interface().(error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+//
+// Replay a JSON-RPC call captured in a testdata file. The first line of the
+// file is the topic ($device/<id>/channel/<channel>), the rest is the
+// JSON-RPC request. The device is the exported device whose id matches the
+// topic or, if there is only one exported device, that device.
+//
+func (conn *Connection) Replay(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	topic, err := reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("%s: missing topic: %s", path, err)
+	}
+
+	parts := strings.Split(strings.TrimSpace(topic), "/")
+	if len(parts) != 4 || parts[0] != "$device" || parts[2] != "channel" {
+		return fmt.Errorf("%s: unexpected topic %s", path, topic)
+	}
+
+	request := struct {
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}{}
+	err = json.NewDecoder(reader).Decode(&request)
+	if err != nil {
+		return fmt.Errorf("%s: invalid request: %s", path, err)
+	}
+
+	device := conn.findDevice(parts[1])
+	if device == nil {
+		return fmt.Errorf("%s: no exported device matches %s", path, parts[1])
+	}
+	return conn.Call(device, parts[3], request.Method, request.Params)
+}
+
+func (conn *Connection) findDevice(id string) ninja.Device {
+	devices := conn.Devices()
+	for _, device := range devices {
+		info := device.GetDeviceInfo()
+		if info.ID == id || info.NaturalID == id {
+			return device
+		}
+	}
+	if len(devices) == 1 {
+		return devices[0]
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package fake
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ninjasphere/go-ninja/api"
+	"github.com/ninjasphere/go-ninja/model"
+
+	"github.com/ninjasphere/driver-go-zwave/spi"
+)
+
+type testDevice struct {
+	info *model.Device
+}
+
+func newTestDevice(naturalID string) *testDevice {
+	return &testDevice{&model.Device{NaturalID: naturalID}}
+}
+
+func (device *testDevice) GetDriver() ninja.Driver {
+	return nil
+}
+
+func (device *testDevice) GetDeviceInfo() *model.Device {
+	return device.info
+}
+
+func (device *testDevice) SetEventHandler(func(event string, payload interface{}) error) {
+}
+
+//
+// testChannel records the calls made to its methods.
+//
+type testChannel struct {
+	*spi.StateChannel
+	calls []interface{}
+}
+
+func (channel *testChannel) TurnOn() error {
+	channel.calls = append(channel.calls, "turnOn")
+	return nil
+}
+
+func (channel *testChannel) Set(level float64) error {
+	channel.calls = append(channel.calls, level)
+	return nil
+}
+
+func TestExportsAreRecorded(t *testing.T) {
+	conn := NewConnection()
+	device := newTestDevice("abc")
+	channel := &testChannel{StateChannel: spi.NewStateChannel("light")}
+
+	if err := conn.ExportChannel(device, channel, "light"); err == nil {
+		t.Error("expected a channel of an unexported device to be refused")
+	}
+	if err := conn.ExportDevice(device); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.ExportDevice(device); err == nil {
+		t.Error("expected a device to be exported only once, as the RPC layer requires")
+	}
+	if err := conn.ExportChannel(device, channel, "light"); err != nil {
+		t.Fatal(err)
+	}
+	if conn.Channel(device, "light") != channel {
+		t.Error("expected the channel to be found by id")
+	}
+
+	channel.SendState(true)
+	expected := []Event{{device, "light", "state", true}}
+	if events := conn.Events(); !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected events %v, got %v", expected, events)
+	}
+	conn.ClearEvents()
+	if events := conn.Events(); len(events) != 0 {
+		t.Errorf("expected no events once cleared, got %v", events)
+	}
+}
+
+func TestCallDecodesParameters(t *testing.T) {
+	conn := NewConnection()
+	device := newTestDevice("abc")
+	channel := &testChannel{StateChannel: spi.NewStateChannel("brightness")}
+	conn.ExportDevice(device)
+	conn.ExportChannel(device, channel, "brightness")
+
+	if err := conn.Call(device, "brightness", "set", []json.RawMessage{json.RawMessage("0.25")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Call(device, "brightness", "set", nil); err == nil {
+		t.Error("expected a call with missing parameters to fail")
+	}
+	if err := conn.Call(device, "brightness", "dim", nil); err == nil {
+		t.Error("expected a call of an unknown method to fail")
+	}
+	if err := conn.Call(device, "on-off", "turnOn", nil); err == nil {
+		t.Error("expected a call of an unexported channel to fail")
+	}
+	if !reflect.DeepEqual(channel.calls, []interface{}{0.25}) {
+		t.Errorf("expected set(0.25) to be called, got %v", channel.calls)
+	}
+}
+
+func TestReplayCallsCapturedRequests(t *testing.T) {
+	conn := NewConnection()
+	device := newTestDevice("abc")
+	onOff := &testChannel{StateChannel: spi.NewStateChannel("on-off")}
+	brightness := &testChannel{StateChannel: spi.NewStateChannel("brightness")}
+	conn.ExportDevice(device)
+	conn.ExportChannel(device, onOff, "on-off")
+	conn.ExportChannel(device, brightness, "brightness")
+
+	for _, file := range []string{"turn-on.json", "brightness-0.5.json"} {
+		if err := conn.Replay(filepath.Join("..", "testdata", file)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !reflect.DeepEqual(onOff.calls, []interface{}{"turnOn"}) {
+		t.Errorf("expected turnOn to be called, got %v", onOff.calls)
+	}
+	if !reflect.DeepEqual(brightness.calls, []interface{}{0.5}) {
+		t.Errorf("expected set(0.5) to be called, got %v", brightness.calls)
+	}
+}
+
+func TestReplayNeedsMatchingDevice(t *testing.T) {
+	conn := NewConnection()
+	conn.ExportDevice(newTestDevice("abc"))
+	conn.ExportDevice(newTestDevice("def"))
+
+	if err := conn.Replay(filepath.Join("..", "testdata", "turn-on.json")); err == nil {
+		t.Error("expected the replay to fail when no device matches the topic")
+	}
+}
+
+func TestReconnectCallsConnectCallbacks(t *testing.T) {
+	conn := NewConnection()
+	calls := 0
+	conn.OnConnect(func() { calls++ })
+	conn.OnConnect(func() { calls++ })
+
+	conn.Reconnect()
+	if calls != 2 {
+		t.Errorf("expected both callbacks to be called, got %d calls", calls)
+	}
+}
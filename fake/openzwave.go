@@ -0,0 +1,716 @@
+// Provides in-memory fakes of the OpenZWave and ninja interfaces, so that device adapters can be driven by scripted tests without hardware
+package fake
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ninjasphere/go-ninja/logger"
+	"github.com/ninjasphere/go-openzwave"
+	"github.com/ninjasphere/go-openzwave/NT"
+
+	"github.com/ninjasphere/driver-go-zwave/spi"
+)
+
+//
+// API is an in-memory implementation of openzwave.API. Nodes join and leave
+// the network when the test says so, or when the test completes an
+// inclusion or exclusion started by the code under test; the device factory
+// and the notification callback are called as the real API would call them.
+//
+// Notifications caused by the test (Join, Leave, Emit) are dispatched
+// synchronously. Notifications caused by the code under test (Refresh) are
+// dispatched asynchronously, since the real API never calls back into the
+// caller's goroutine.
+//
+type API struct {
+	sync.Mutex
+
+	factory  openzwave.DeviceFactory
+	callback openzwave.NotificationCallback
+	logger   openzwave.Logger
+
+	homeId    uint32
+	nodes     map[uint8]*Node
+	devices   map[uint8]openzwave.Device
+	neighbors map[uint8][]uint8
+	silent    map[uint8]bool // nodes that never answer heals
+	command   *command       // the controller command in progress, if any
+
+	quit chan int
+}
+
+//
+// command is a controller command in progress. The callback is called as
+// the state of the command changes.
+//
+type command struct {
+	callback func(state int)
+}
+
+var _ openzwave.API = (*API)(nil)
+var _ spi.Controller = (*API)(nil)
+var _ spi.Healer = (*API)(nil)
+
+func NewAPI(factory openzwave.DeviceFactory, callback openzwave.NotificationCallback) *API {
+	if callback == nil {
+		callback = func(openzwave.API, openzwave.Notification) {}
+	}
+	return &API{
+		factory:   factory,
+		callback:  callback,
+		logger:    logger.GetLogger("fake.openzwave"),
+		homeId:    0xcafebabe,
+		nodes:     make(map[uint8]*Node),
+		devices:   make(map[uint8]openzwave.Device),
+		neighbors: make(map[uint8][]uint8),
+		silent:    make(map[uint8]bool),
+		quit:      make(chan int, 1),
+	}
+}
+
+func (api *API) Logger() openzwave.Logger {
+	return api.logger
+}
+
+//
+// Shutdown records the exit code, which can be collected from QuitSignal.
+//
+func (api *API) Shutdown(exit int) {
+	select {
+	case api.quit <- exit:
+	default:
+	}
+}
+
+func (api *API) QuitSignal() chan int {
+	return api.quit
+}
+
+//
+// Change the home id reported by the nodes of the network.
+//
+func (api *API) SetHomeId(homeId uint32) {
+	api.homeId = homeId
+}
+
+//
+// Create a node with the specified id and product. Values are added to the
+// node with AddValue before the node joins the network with Join.
+//
+func (api *API) NewNode(id uint8, productId openzwave.ProductId, description openzwave.ProductDescription) *Node {
+	return &Node{
+		api:         api,
+		id:          id,
+		productId:   productId,
+		description: description,
+		values:      make(map[openzwave.ValueID]*Value),
+	}
+}
+
+//
+// Add the node to the network: the device factory builds a device for the
+// node, the device is told the node has been added and a NODE_ADDED
+// notification is sent.
+//
+func (api *API) Join(node *Node) openzwave.Device {
+	device := api.factory(api, node)
+
+	api.Lock()
+	api.nodes[node.id] = node
+	api.devices[node.id] = device
+	api.Unlock()
+
+	device.NodeAdded()
+	api.notify(NT.NODE_ADDED, node, nil)
+	return device
+}
+
+//
+// Remove the node from the network: the device is told the node has been
+// removed and a NODE_REMOVED notification is sent.
+//
+func (api *API) Leave(id uint8) {
+	api.Lock()
+	node, ok := api.nodes[id]
+	device := api.devices[id]
+	delete(api.nodes, id)
+	delete(api.devices, id)
+	api.Unlock()
+
+	if !ok {
+		return
+	}
+	device.NodeRemoved()
+	api.notify(NT.NODE_REMOVED, node, nil)
+}
+
+//
+// Answer the device built for the node with the specified id, or nil.
+//
+func (api *API) Device(id uint8) openzwave.Device {
+	api.Lock()
+	defer api.Unlock()
+	return api.devices[id]
+}
+
+//
+// Send an arbitrary notification, as if it came from the network. The
+// device of the node is told of value changes.
+//
+func (api *API) Notify(code int, node *Node, value *Value) {
+	if code == NT.VALUE_CHANGED && node != nil && value != nil {
+		device := api.Device(node.id)
+		if device != nil {
+			device.ValueChanged(value)
+		}
+	}
+	api.notify(code, node, value)
+}
+
+//
+// Send a NOTIFICATION notification with the specified notification code
+// (e.g. spi.CODE_AWAKE) for the node.
+//
+func (api *API) NotifyCode(code int, node *Node) {
+	nt := &Notification{NotificationType: NT.ToEnum(NT.NOTIFICATION), Code: code}
+	if node != nil {
+		nt.Node = node
+	}
+	api.callback(api, nt)
+}
+
+//
+// Send a NOTIFICATION notification without a code for the node, as
+// versions of go-openzwave that do not expose notification codes do.
+//
+func (api *API) NotifyWithoutCode(node *Node) {
+	nt := &uncodedNotification{NT.ToEnum(NT.NOTIFICATION), nil}
+	if node != nil {
+		nt.node = node
+	}
+	api.callback(api, nt)
+}
+
+//
+// Set the neighbours of the node in the routing table.
+//
+func (api *API) SetNeighbors(id uint8, neighbors ...uint8) {
+	api.Lock()
+	defer api.Unlock()
+	api.neighbors[id] = neighbors
+}
+
+//
+// Start an inclusion. The controller waits until the test calls Include, or
+// the inclusion is cancelled.
+//
+func (api *API) AddNode(secure bool, callback func(state int)) bool {
+	return api.startPairing(callback)
+}
+
+//
+// Start an exclusion. The controller waits until the test calls Exclude, or
+// the exclusion is cancelled.
+//
+func (api *API) RemoveNode(callback func(state int)) bool {
+	return api.startPairing(callback)
+}
+
+//
+// As the real controller does, the starting and waiting states are reported
+// before the command returns.
+//
+func (api *API) startPairing(callback func(state int)) bool {
+	if api.start(callback) == nil {
+		return false
+	}
+	callback(int(spi.ControllerStarting))
+	callback(int(spi.ControllerWaiting))
+	return true
+}
+
+//
+// Complete the inclusion in progress by adding the node to the network.
+// Answers the device built for the node, or nil if no inclusion is in
+// progress.
+//
+func (api *API) Include(node *Node) openzwave.Device {
+	cmd := api.current()
+	if cmd == nil {
+		return nil
+	}
+	cmd.callback(int(spi.ControllerInProgress))
+	device := api.Join(node)
+	api.finish(cmd, spi.ControllerCompleted)
+	return device
+}
+
+//
+// Complete the exclusion in progress by removing the node from the network.
+// Answers false if no exclusion is in progress.
+//
+func (api *API) Exclude(id uint8) bool {
+	cmd := api.current()
+	if cmd == nil {
+		return false
+	}
+	cmd.callback(int(spi.ControllerInProgress))
+	api.Leave(id)
+	api.finish(cmd, spi.ControllerCompleted)
+	return true
+}
+
+//
+// Make the node ignore heals and neighbour updates, so that they never
+// complete unless cancelled.
+//
+func (api *API) SetSilent(id uint8, silent bool) {
+	api.Lock()
+	defer api.Unlock()
+	api.silent[id] = silent
+}
+
+//
+// Heal the node. The heal completes asynchronously, with the node-ok state
+// if the node is on the network, and the node-failed state otherwise. The
+// heal of a silent node never completes.
+//
+func (api *API) HealNetworkNode(id uint8, returnRoutes bool, callback func(state int)) bool {
+	return api.RequestNodeNeighborUpdate(id, callback)
+}
+
+func (api *API) RequestNodeNeighborUpdate(id uint8, callback func(state int)) bool {
+	cmd := api.start(callback)
+	if cmd == nil {
+		return false
+	}
+
+	api.Lock()
+	_, ok := api.nodes[id]
+	silent := api.silent[id]
+	api.Unlock()
+
+	go func() {
+		cmd.callback(int(spi.ControllerInProgress))
+		if silent {
+			return
+		}
+		if ok {
+			api.finish(cmd, spi.ControllerNodeOK)
+		} else {
+			api.finish(cmd, spi.ControllerNodeFailed)
+		}
+	}()
+	return true
+}
+
+func (api *API) GetNodeNeighbors(id uint8) []uint8 {
+	api.Lock()
+	defer api.Unlock()
+	return append([]uint8{}, api.neighbors[id]...)
+}
+
+//
+// Cancel the controller command in progress, which reports the cancel state.
+//
+func (api *API) CancelControllerCommand() bool {
+	cmd := api.current()
+	if cmd == nil {
+		return false
+	}
+	api.finish(cmd, spi.ControllerCancel)
+	return true
+}
+
+//
+// Answer true if a controller command is in progress.
+//
+func (api *API) Busy() bool {
+	return api.current() != nil
+}
+
+//
+// Start a controller command, unless one is already in progress.
+//
+func (api *API) start(callback func(state int)) *command {
+	api.Lock()
+	defer api.Unlock()
+	if api.command != nil {
+		return nil
+	}
+	api.command = &command{callback}
+	return api.command
+}
+
+func (api *API) current() *command {
+	api.Lock()
+	defer api.Unlock()
+	return api.command
+}
+
+//
+// Finish the command with the specified state, unless it has already
+// finished.
+//
+func (api *API) finish(cmd *command, state spi.ControllerState) {
+	api.Lock()
+	if api.command != cmd {
+		api.Unlock()
+		return
+	}
+	api.command = nil
+	api.Unlock()
+
+	cmd.callback(int(state))
+}
+
+func (api *API) notify(code int, node *Node, value *Value) {
+	nt := &Notification{NotificationType: NT.ToEnum(code)}
+	if node != nil {
+		nt.Node = node
+	}
+	if value != nil {
+		nt.Value = value
+	}
+	api.callback(api, nt)
+}
+
+//
+// Notification is a notification sent by the fake API. Code is the
+// notification code of NOTIFICATION notifications.
+//
+type Notification struct {
+	NotificationType *NT.Enum
+	Node             openzwave.Node
+	Value            openzwave.Value
+	Code             int
+}
+
+func (nt *Notification) GetNotificationType() *NT.Enum {
+	return nt.NotificationType
+}
+
+func (nt *Notification) GetNode() openzwave.Node {
+	return nt.Node
+}
+
+func (nt *Notification) GetValue() openzwave.Value {
+	return nt.Value
+}
+
+func (nt *Notification) GetNotificationCode() int {
+	return nt.Code
+}
+
+type uncodedNotification struct {
+	notificationType *NT.Enum
+	node             openzwave.Node
+}
+
+func (nt *uncodedNotification) GetNotificationType() *NT.Enum {
+	return nt.notificationType
+}
+
+func (nt *uncodedNotification) GetNode() openzwave.Node {
+	return nt.node
+}
+
+func (nt *uncodedNotification) GetValue() openzwave.Value {
+	return nil
+}
+
+//
+// Node is an in-memory implementation of openzwave.Node.
+//
+type Node struct {
+	api         *API
+	id          uint8
+	productId   openzwave.ProductId
+	description openzwave.ProductDescription
+
+	mutex  sync.Mutex
+	values map[openzwave.ValueID]*Value
+}
+
+var _ openzwave.Node = (*Node)(nil)
+
+func (node *Node) GetHomeId() uint32 {
+	return node.api.homeId
+}
+
+func (node *Node) GetId() uint8 {
+	return node.id
+}
+
+func (node *Node) GetProductId() *openzwave.ProductId {
+	return &node.productId
+}
+
+func (node *Node) GetProductDescription() *openzwave.ProductDescription {
+	return &node.description
+}
+
+func (node *Node) GetValue(commandClassId uint8, instance uint8, index uint8) openzwave.Value {
+	return node.GetValueWithId(openzwave.ValueID{commandClassId, instance, index})
+}
+
+//
+// Answer the value with the specified id. Like the real API, a value that
+// the node does not have is answered as a value whose getters all fail.
+//
+func (node *Node) GetValueWithId(id openzwave.ValueID) openzwave.Value {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	value, ok := node.values[id]
+	if !ok {
+		return &Value{node: node, id: id, missing: true}
+	}
+	return value
+}
+
+func (node *Node) HasValue(id openzwave.ValueID) bool {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	_, ok := node.values[id]
+	return ok
+}
+
+//
+// Add a value to the node. The type of the initial value (bool, uint8,
+// float64 or string) determines which getters and setters succeed.
+//
+func (node *Node) AddValue(id openzwave.ValueID, initial interface{}) *Value {
+	value := &Value{
+		node:     node,
+		id:       id,
+		current:  initial,
+		behavior: Acknowledge,
+	}
+
+	node.mutex.Lock()
+	node.values[id] = value
+	node.mutex.Unlock()
+
+	return value
+}
+
+//
+// Behavior determines how a value responds to sets.
+//
+type Behavior int
+
+const (
+	Acknowledge Behavior = iota // the set succeeds and the device adopts the new value
+	Ignore                      // the set succeeds but the device keeps its current value
+	Reject                      // the set fails
+)
+
+//
+// Value is an in-memory implementation of openzwave.Value. The value held by
+// the fake is the value held by the device; it is reported to the device
+// adapter with ValueChanged when the value is refreshed or emitted.
+//
+type Value struct {
+	node    *Node
+	id      openzwave.ValueID
+	missing bool
+
+	mutex        sync.Mutex
+	current      interface{}
+	behavior     Behavior
+	refreshDelay time.Duration
+	polling      bool
+	sets         []interface{}
+	refreshes    int
+}
+
+var _ openzwave.Value = (*Value)(nil)
+
+func (v *Value) Id() openzwave.ValueID {
+	return v.id
+}
+
+//
+// Choose how the value responds to subsequent sets.
+//
+func (v *Value) SetBehavior(behavior Behavior) *Value {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.behavior = behavior
+	return v
+}
+
+//
+// Delay the ValueChanged call that follows each refresh.
+//
+func (v *Value) SetRefreshDelay(delay time.Duration) *Value {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.refreshDelay = delay
+	return v
+}
+
+//
+// Change the value, as if the device had reported a change, and
+// synchronously notify the device adapter.
+//
+func (v *Value) Emit(next interface{}) {
+	v.mutex.Lock()
+	v.current = next
+	v.mutex.Unlock()
+	v.node.api.Notify(NT.VALUE_CHANGED, v.node, v)
+}
+
+//
+// Change the value without notifying the device adapter.
+//
+func (v *Value) Update(next interface{}) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.current = next
+}
+
+//
+// Answer the values passed to the setters, in order.
+//
+func (v *Value) Sets() []interface{} {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return append([]interface{}{}, v.sets...)
+}
+
+func (v *Value) Refreshes() int {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.refreshes
+}
+
+func (v *Value) IsPolled() bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.polling
+}
+
+func (v *Value) get() (interface{}, bool) {
+	if v.missing {
+		return nil, false
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.current, true
+}
+
+func (v *Value) set(next interface{}) bool {
+	if v.missing {
+		return false
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if !sameType(v.current, next) {
+		return false
+	}
+	v.sets = append(v.sets, next)
+	switch v.behavior {
+	case Acknowledge:
+		v.current = next
+		return true
+	case Ignore:
+		return true
+	default:
+		return false
+	}
+}
+
+func sameType(a interface{}, b interface{}) bool {
+	switch a.(type) {
+	case bool:
+		_, ok := b.(bool)
+		return ok
+	case uint8:
+		_, ok := b.(uint8)
+		return ok
+	case float64:
+		_, ok := b.(float64)
+		return ok
+	case string:
+		_, ok := b.(string)
+		return ok
+	default:
+		return false
+	}
+}
+
+func (v *Value) GetUint8() (uint8, bool) {
+	current, _ := v.get()
+	result, ok := current.(uint8)
+	return result, ok
+}
+
+func (v *Value) SetUint8(next uint8) bool {
+	return v.set(next)
+}
+
+func (v *Value) GetBool() (bool, bool) {
+	current, _ := v.get()
+	result, ok := current.(bool)
+	return result, ok
+}
+
+func (v *Value) SetBool(next bool) bool {
+	return v.set(next)
+}
+
+func (v *Value) GetFloat() (float64, bool) {
+	current, _ := v.get()
+	result, ok := current.(float64)
+	return result, ok
+}
+
+func (v *Value) SetFloat(next float64) bool {
+	return v.set(next)
+}
+
+func (v *Value) GetString() (string, bool) {
+	current, _ := v.get()
+	result, ok := current.(string)
+	return result, ok
+}
+
+func (v *Value) SetString(next string) bool {
+	return v.set(next)
+}
+
+//
+// Refresh asynchronously reports the current value to the device adapter,
+// after the configured refresh delay.
+//
+func (v *Value) Refresh() bool {
+	if v.missing {
+		return false
+	}
+
+	v.mutex.Lock()
+	v.refreshes++
+	delay := v.refreshDelay
+	v.mutex.Unlock()
+
+	go func() {
+		time.Sleep(delay)
+		v.node.api.Notify(NT.VALUE_CHANGED, v.node, v)
+	}()
+	return true
+}
+
+func (v *Value) SetPollingState(state bool) bool {
+	if v.missing {
+		return false
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.polling = state
+	return true
+}
@@ -0,0 +1,215 @@
+package fake
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ninjasphere/go-openzwave"
+	"github.com/ninjasphere/go-openzwave/CC"
+	"github.com/ninjasphere/go-openzwave/NT"
+
+	"github.com/ninjasphere/driver-go-zwave/spi"
+)
+
+var (
+	testProduct     = openzwave.ProductId{"0086", "0003"}
+	testDescription = openzwave.ProductDescription{"Aeon Labs", "Smart Energy Switch", "Switch"}
+	testSwitch      = openzwave.ValueID{CC.SWITCH_BINARY, 1, 0}
+)
+
+//
+// recorder is a device and notification callback that records the calls
+// made by the fake API.
+//
+type recorder struct {
+	sync.Mutex
+	calls   []string
+	changed chan openzwave.Value
+}
+
+func newRecorder() *recorder {
+	return &recorder{changed: make(chan openzwave.Value, 16)}
+}
+
+func (r *recorder) record(call string) {
+	r.Lock()
+	defer r.Unlock()
+	r.calls = append(r.calls, call)
+}
+
+func (r *recorder) Calls() []string {
+	r.Lock()
+	defer r.Unlock()
+	return append([]string{}, r.calls...)
+}
+
+func (r *recorder) NodeAdded()   { r.record("added") }
+func (r *recorder) NodeChanged() { r.record("changed") }
+func (r *recorder) NodeRemoved() { r.record("removed") }
+
+func (r *recorder) ValueChanged(v openzwave.Value) {
+	r.record("value")
+	r.changed <- v
+}
+
+func (r *recorder) notified(api openzwave.API, nt openzwave.Notification) {
+	switch nt.GetNotificationType().Code {
+	case NT.NODE_ADDED:
+		r.record("NODE_ADDED")
+	case NT.NODE_REMOVED:
+		r.record("NODE_REMOVED")
+	}
+}
+
+func newTestAPI() (*API, *recorder) {
+	r := newRecorder()
+	api := NewAPI(func(openzwave.API, openzwave.Node) openzwave.Device { return r }, r.notified)
+	return api, r
+}
+
+func TestJoinAndLeave(t *testing.T) {
+	api, r := newTestAPI()
+	node := api.NewNode(2, testProduct, testDescription)
+
+	if api.Join(node) != r {
+		t.Error("expected Join to answer the device built by the factory")
+	}
+	if api.Device(2) != r {
+		t.Error("expected the device to be found by node id")
+	}
+	api.Leave(2)
+	api.Leave(2)
+
+	expected := []string{"added", "NODE_ADDED", "removed", "NODE_REMOVED"}
+	if calls := r.Calls(); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected calls %v, got %v", expected, calls)
+	}
+	if api.Device(2) != nil {
+		t.Error("expected no device once the node has left")
+	}
+}
+
+func TestValueBehaviors(t *testing.T) {
+	api, _ := newTestAPI()
+	node := api.NewNode(2, testProduct, testDescription)
+	value := node.AddValue(testSwitch, false)
+
+	if !value.SetBool(true) {
+		t.Error("expected an acknowledged set to succeed")
+	}
+	if current, _ := value.GetBool(); !current {
+		t.Error("expected an acknowledged set to change the value")
+	}
+
+	value.SetBehavior(Ignore)
+	if !value.SetBool(false) {
+		t.Error("expected an ignored set to succeed")
+	}
+	if current, _ := value.GetBool(); !current {
+		t.Error("expected an ignored set not to change the value")
+	}
+
+	value.SetBehavior(Reject)
+	if value.SetBool(false) {
+		t.Error("expected a rejected set to fail")
+	}
+	if value.SetUint8(1) {
+		t.Error("expected a set of the wrong type to fail")
+	}
+
+	sets := value.Sets()
+	if len(sets) != 3 || sets[0] != true || sets[1] != false || sets[2] != false {
+		t.Errorf("expected the sets of the right type to be recorded, got %v", sets)
+	}
+}
+
+func TestMissingValue(t *testing.T) {
+	api, _ := newTestAPI()
+	node := api.NewNode(2, testProduct, testDescription)
+
+	value := node.GetValueWithId(testSwitch)
+	if value == nil {
+		t.Fatal("expected a missing value rather than nil, as OpenZWave answers")
+	}
+	if _, ok := value.GetBool(); ok {
+		t.Error("expected a missing value to have no state")
+	}
+	if value.SetBool(true) || value.Refresh() {
+		t.Error("expected sets and refreshes of a missing value to fail")
+	}
+}
+
+func TestRefreshIsAsynchronous(t *testing.T) {
+	api, r := newTestAPI()
+	node := api.NewNode(2, testProduct, testDescription)
+	value := node.AddValue(testSwitch, false)
+	api.Join(node)
+
+	value.SetRefreshDelay(10 * time.Millisecond)
+	if !value.Refresh() {
+		t.Fatal("expected the refresh to succeed")
+	}
+	select {
+	case v := <-r.changed:
+		if v.Id() != testSwitch {
+			t.Errorf("expected the refreshed value to be reported, got %v", v.Id())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the refresh to be reported")
+	}
+	if value.Refreshes() != 1 {
+		t.Errorf("expected 1 refresh, got %d", value.Refreshes())
+	}
+}
+
+func TestControllerCommands(t *testing.T) {
+	api, r := newTestAPI()
+	states := make([]spi.ControllerState, 0)
+	callback := func(state int) { states = append(states, spi.ControllerState(state)) }
+
+	if api.Include(api.NewNode(2, testProduct, testDescription)) != nil {
+		t.Error("expected no node to be included without an inclusion")
+	}
+	if !api.AddNode(false, callback) {
+		t.Fatal("expected the inclusion to start")
+	}
+	if api.RemoveNode(callback) {
+		t.Error("expected a second controller command to be refused")
+	}
+	if api.Include(api.NewNode(2, testProduct, testDescription)) != r {
+		t.Error("expected the included node's device to be answered")
+	}
+	if api.Busy() {
+		t.Error("expected the controller to be idle after the inclusion")
+	}
+
+	expected := []spi.ControllerState{spi.ControllerStarting, spi.ControllerWaiting, spi.ControllerInProgress, spi.ControllerCompleted}
+	if !reflect.DeepEqual(states, expected) {
+		t.Errorf("expected states %v, got %v", expected, states)
+	}
+}
+
+func TestHealOfMissingNodeFails(t *testing.T) {
+	api, _ := newTestAPI()
+	done := make(chan spi.ControllerState, 4)
+
+	if !api.HealNetworkNode(9, false, func(state int) { done <- spi.ControllerState(state) }) {
+		t.Fatal("expected the heal to start")
+	}
+	for {
+		select {
+		case state := <-done:
+			if state == spi.ControllerInProgress {
+				continue
+			}
+			if state != spi.ControllerNodeFailed {
+				t.Errorf("expected the heal of a missing node to fail, got %v", state)
+			}
+			return
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the heal to finish")
+		}
+	}
+}
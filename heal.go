@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ninjasphere/driver-go-zwave/spi"
+	"github.com/ninjasphere/driver-go-zwave/utils"
+)
+
+const (
+	heal           = "heal"
+	neighborUpdate = "neighbor-update"
+)
+
+var (
+	healNodeTimeout = 2 * time.Minute // the heal of a node is cancelled if it takes longer than this
+)
+
+//
+// HealEvent reports the progress of a heal or neighbour update. It is sent
+// as a "heal" or "neighbor-update" event. Events for a node carry the state
+// of the node's command, which is "skipped" for sleeping and offline nodes
+// during a network heal, or "timeout" if the node did not respond in time.
+//
+type HealEvent struct {
+	State string `json:"state"`
+	Node  uint8  `json:"node,omitempty"`
+	Done  int    `json:"done"`  // the number of nodes that have been dealt with
+	Total int    `json:"total"` // the number of nodes to be dealt with
+}
+
+type NodeRequest struct {
+	Node uint8 `json:"node"`
+}
+
+//
+// healing tracks the heal or neighbour update that is in progress, if any,
+// and the next scheduled heal. The controller is claimed for the duration
+// of a heal, so a heal cannot overlap an inclusion or exclusion.
+//
+type healing struct {
+	sync.Mutex
+	cancel chan struct{} // closed to cancel the heal in progress
+	timer  utils.Timer   // the next scheduled heal
+}
+
+type healStep func(healer spi.Healer, node uint8, callback func(state int)) bool
+
+//
+// Heal every node on the network in turn. Nodes that sleep or are offline
+// are skipped, since they cannot respond.
+//
+func (d *ZDriver) HealNetwork() error {
+	return d.startHeal(heal, d.nodeIds(), true, d.healNode)
+}
+
+//
+// Heal a single node, whether or not it is asleep or offline.
+//
+func (d *ZDriver) HealNode(request NodeRequest) error {
+	if err := d.checkNode(heal, request.Node); err != nil {
+		return err
+	}
+	return d.startHeal(heal, []uint8{request.Node}, false, d.healNode)
+}
+
+//
+// Ask a node to rediscover its neighbours, without updating its return
+// routes.
+//
+func (d *ZDriver) RequestNeighborUpdate(request NodeRequest) error {
+	if err := d.checkNode(neighborUpdate, request.Node); err != nil {
+		return err
+	}
+	return d.startHeal(neighborUpdate, []uint8{request.Node}, false, func(healer spi.Healer, node uint8, callback func(state int)) bool {
+		return healer.RequestNodeNeighborUpdate(node, callback)
+	})
+}
+
+func (d *ZDriver) CancelHeal() error {
+	d.healing.Lock()
+	defer d.healing.Unlock()
+	if d.healing.cancel == nil {
+		return fmt.Errorf("Unable to cancel heal - no heal is in progress")
+	}
+	close(d.healing.cancel)
+	d.healing.cancel = nil
+	return nil
+}
+
+//
+// Answer an error unless the node is on the network. Node 0 is what a
+// request without a node decodes to.
+//
+func (d *ZDriver) checkNode(mode string, node uint8) error {
+	if node == 0 {
+		return fmt.Errorf("Unable to start %s - a node is required", mode)
+	}
+	d.mutex.Lock()
+	_, ok := d.byNode[node]
+	d.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("Unable to start %s - node %d is not on the network", mode, node)
+	}
+	return nil
+}
+
+//
+// Answer the neighbours of the node in the controller's routing table.
+//
+func (d *ZDriver) GetNeighbors(request NodeRequest) ([]int, error) {
+	healer, ok := spi.GetHealer(d.ZWave())
+	if !ok {
+		return nil, fmt.Errorf("Unable to get neighbors - routing tables are not available")
+	}
+	return neighborIds(healer.GetNodeNeighbors(request.Node)), nil
+}
+
+//
+// Answer the controller's routing table: the neighbours of each node, by
+// node id.
+//
+func (d *ZDriver) GetRoutingTable() (map[uint8][]int, error) {
+	healer, ok := spi.GetHealer(d.ZWave())
+	if !ok {
+		return nil, fmt.Errorf("Unable to get routing table - routing tables are not available")
+	}
+	table := make(map[uint8][]int)
+	for _, node := range d.nodeIds() {
+		table[node] = neighborIds(healer.GetNodeNeighbors(node))
+	}
+	return table, nil
+}
+
+//
+// Answer the neighbours as ints, since a []uint8 would be encoded as a
+// base64 string.
+//
+func neighborIds(neighbors []uint8) []int {
+	ids := make([]int, len(neighbors))
+	for i, neighbor := range neighbors {
+		ids[i] = int(neighbor)
+	}
+	return ids
+}
+
+func (d *ZDriver) healNode(healer spi.Healer, node uint8, callback func(state int)) bool {
+	return healer.HealNetworkNode(node, d.configuration().HealReturnRoutes, callback)
+}
+
+//
+// Answer the ids of the nodes on the network, in order.
+//
+func (d *ZDriver) nodeIds() []uint8 {
+	d.mutex.Lock()
+	ids := make([]int, 0, len(d.byNode))
+	for id := range d.byNode {
+		ids = append(ids, int(id))
+	}
+	d.mutex.Unlock()
+
+	sort.Ints(ids)
+	nodes := make([]uint8, len(ids))
+	for i, id := range ids {
+		nodes[i] = uint8(id)
+	}
+	return nodes
+}
+
+//
+// Answer true if the node cannot be expected to respond to a heal.
+//
+func (d *ZDriver) isUnreachable(node uint8) bool {
+	d.mutex.Lock()
+	device := d.byNode[node]
+	d.mutex.Unlock()
+	return device != nil && (device.Sleeps() || !device.Health().Online)
+}
+
+func (d *ZDriver) startHeal(mode string, nodes []uint8, skipUnreachable bool, step healStep) error {
+	healer, ok := spi.GetHealer(d.ZWave())
+	if !ok {
+		return fmt.Errorf("Unable to start %s - network heals are not available", mode)
+	}
+	if len(nodes) == 0 {
+		return fmt.Errorf("Unable to start %s - there are no nodes", mode)
+	}
+	if err := d.claimController(mode); err != nil {
+		return err
+	}
+
+	cancel := make(chan struct{})
+	d.healing.Lock()
+	d.healing.cancel = cancel
+	d.healing.Unlock()
+
+	d.Log.Infof("Started %s of %d node(s)", mode, len(nodes))
+	d.SendEvent(mode, &HealEvent{State: "started", Total: len(nodes)})
+
+	go d.runHeal(mode, healer, nodes, skipUnreachable, step, cancel)
+	return nil
+}
+
+//
+// Deal with each node in turn, waiting for the command for one node to
+// finish before starting the command for the next, since the controller
+// runs one command at a time.
+//
+func (d *ZDriver) runHeal(mode string, healer spi.Healer, nodes []uint8, skipUnreachable bool, step healStep, cancel chan struct{}) {
+	result := "completed"
+	done := 0
+	for _, node := range nodes {
+		if isClosed(cancel) {
+			result = "cancelled"
+			break
+		}
+
+		var state string
+		if skipUnreachable && d.isUnreachable(node) {
+			state = "skipped"
+		} else {
+			state = d.runHealStep(mode, healer, node, step, cancel, &HealEvent{Node: node, Done: done, Total: len(nodes)})
+		}
+		done++
+		d.SendEvent(mode, &HealEvent{State: state, Node: node, Done: done, Total: len(nodes)})
+
+		if state == "cancelled" {
+			result = state
+			break
+		}
+	}
+
+	d.healing.Lock()
+	if d.healing.cancel == cancel {
+		d.healing.cancel = nil
+	}
+	d.healing.Unlock()
+	d.releaseController(mode)
+
+	d.Log.Infof("Finished %s: %s", mode, result)
+	d.SendEvent(mode, &HealEvent{State: result, Done: done, Total: len(nodes)})
+}
+
+//
+// Run the command for a single node and answer the state it finished in.
+// The callback never blocks, since the controller calls it from its own
+// thread, possibly after the command has timed out.
+//
+func (d *ZDriver) runHealStep(mode string, healer spi.Healer, node uint8, step healStep, cancel chan struct{}, progress *HealEvent) string {
+	states := make(chan spi.ControllerState, 16)
+	callback := func(state int) {
+		select {
+		case states <- spi.ControllerState(state):
+		default:
+		}
+	}
+	if !step(healer, node, callback) {
+		return "rejected"
+	}
+
+	timeout := time.NewTimer(healNodeTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case state := <-states:
+			if state.IsFinal() {
+				return state.String()
+			}
+			progress.State = state.String()
+			d.SendEvent(mode, progress)
+		case <-timeout.C:
+			healer.CancelControllerCommand()
+			return "timeout"
+		case <-cancel:
+			healer.CancelControllerCommand()
+			return "cancelled"
+		}
+	}
+}
+
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+//
+// Schedule the next nightly heal at the configured time of day, replacing
+// any heal already scheduled. Each scheduled heal schedules the next. No
+// heal is scheduled once the API is known not to support heals.
+//
+func (d *ZDriver) scheduleHeal() {
+	hour, minute, ok := d.configuration().healTime()
+	if api := d.ZWave(); api != nil {
+		if _, healer := spi.GetHealer(api); !healer {
+			ok = false
+		}
+	}
+	if !ok {
+		d.cancelHealSchedule()
+		return
+	}
+
+	now := d.Clock().Now()
+	next := nextHealTime(now, hour, minute)
+
+	d.healing.Lock()
+	if d.healing.timer != nil {
+		d.healing.timer.Stop()
+	}
+	d.healing.timer = d.Clock().AfterFunc(next.Sub(now), func() {
+		if err := d.HealNetwork(); err != nil {
+			d.Log.Warningf("Skipped scheduled heal: %s", err)
+		}
+		d.scheduleHeal()
+	})
+	d.healing.Unlock()
+
+	d.Log.Infof("Next network heal scheduled for %s", next)
+}
+
+func (d *ZDriver) cancelHealSchedule() {
+	d.healing.Lock()
+	defer d.healing.Unlock()
+	if d.healing.timer != nil {
+		d.healing.timer.Stop()
+		d.healing.timer = nil
+	}
+}
+
+//
+// Answer the first time after now that falls at the specified time of day.
+//
+func nextHealTime(now time.Time, hour int, minute int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ninjasphere/driver-go-zwave/fake"
+)
+
+func TestNextHealTime(t *testing.T) {
+	for _, test := range []struct {
+		now, next string
+	}{
+		{"2015-06-01T01:00:00Z", "2015-06-01T03:30:00Z"},
+		{"2015-06-01T03:30:00Z", "2015-06-02T03:30:00Z"},
+		{"2015-06-01T23:00:00Z", "2015-06-02T03:30:00Z"},
+		{"2015-06-30T04:00:00Z", "2015-07-01T03:30:00Z"},
+	} {
+		now, _ := time.Parse(time.RFC3339, test.now)
+		expected, _ := time.Parse(time.RFC3339, test.next)
+		if next := nextHealTime(now, 3, 30); !next.Equal(expected) {
+			t.Errorf("expected the heal after %s at %s, got %s", test.now, test.next, next)
+		}
+	}
+}
+
+func TestHealSkipsUnreachableNodes(t *testing.T) {
+	td := newTestDriver()
+	td.api.Join(td.newSwitch(2))
+	offline := td.newSwitch(3)
+	td.api.Join(offline)
+	td.deviceOf(offline).SetOnline(false)
+
+	if err := td.HealNetwork(); err != nil {
+		t.Fatal(err)
+	}
+	if node := td.expect(t, heal, "node-ok").payload.(*HealEvent).Node; node != 2 {
+		t.Errorf("expected node 2 to be healed, got %d", node)
+	}
+	if node := td.expect(t, heal, "skipped").payload.(*HealEvent).Node; node != 3 {
+		t.Errorf("expected node 3 to be skipped, got %d", node)
+	}
+	td.expect(t, heal, "completed")
+}
+
+func TestHealNodeTimesOut(t *testing.T) {
+	defer func(timeout time.Duration) { healNodeTimeout = timeout }(healNodeTimeout)
+	healNodeTimeout = 50 * time.Millisecond
+
+	td := newTestDriver()
+	td.api.Join(td.newSwitch(2))
+	td.api.SetSilent(2, true)
+
+	if err := td.HealNode(NodeRequest{2}); err != nil {
+		t.Fatal(err)
+	}
+	td.expect(t, heal, "timeout")
+	td.expect(t, heal, "completed")
+
+	if td.api.Busy() {
+		t.Error("expected the heal to be cancelled at the controller")
+	}
+}
+
+func TestHealIsCancelled(t *testing.T) {
+	td := newTestDriver()
+	td.api.Join(td.newSwitch(2))
+	td.api.SetSilent(2, true)
+
+	if err := td.HealNode(NodeRequest{2}); err != nil {
+		t.Fatal(err)
+	}
+	td.expect(t, heal, "in-progress")
+	if err := td.CancelHeal(); err != nil {
+		t.Fatal(err)
+	}
+	if node := td.expect(t, heal, "cancelled").payload.(*HealEvent).Node; node != 2 {
+		t.Errorf("expected the heal of node 2 to be cancelled, got %d", node)
+	}
+	td.expect(t, heal, "cancelled")
+
+	if td.api.Busy() {
+		t.Error("expected the heal to be cancelled at the controller")
+	}
+	if err := td.StartInclusion(); err != nil {
+		t.Errorf("expected the controller to be released after the heal: %s", err)
+	}
+}
+
+func TestHealNodeRejectsUnknownNodes(t *testing.T) {
+	td := newTestDriver()
+	td.api.Join(td.newSwitch(2))
+
+	if err := td.HealNode(NodeRequest{}); err == nil {
+		t.Error("expected a heal without a node to be rejected")
+	}
+	if err := td.HealNode(NodeRequest{9}); err == nil {
+		t.Error("expected a heal of a node that is not on the network to be rejected")
+	}
+	if err := td.RequestNeighborUpdate(NodeRequest{9}); err == nil {
+		t.Error("expected a neighbor update of a node that is not on the network to be rejected")
+	}
+	if td.api.Busy() {
+		t.Error("expected no controller command to be started")
+	}
+}
+
+func TestHealIsScheduled(t *testing.T) {
+	td := newTestDriver()
+	clock := fake.NewClock()
+	td.clock = clock
+	td.config.HealSchedule = "03:30"
+	td.api.Join(td.newSwitch(2))
+
+	td.scheduleHeal()
+	if pending := clock.Pending(); pending != 1 {
+		t.Fatalf("expected a heal to be scheduled, got %d timers", pending)
+	}
+
+	clock.Advance(24 * time.Hour)
+	td.expect(t, heal, "started")
+	td.expect(t, heal, "completed")
+	if pending := clock.Pending(); pending != 1 {
+		t.Errorf("expected the next heal to be scheduled, got %d timers", pending)
+	}
+}
+
+func TestHealScheduleIsCancelledWhenCleared(t *testing.T) {
+	td := newTestDriver()
+	clock := fake.NewClock()
+	td.clock = clock
+	td.config.HealSchedule = "03:30"
+
+	td.scheduleHeal()
+	td.config = defaultConfig()
+	td.scheduleHeal()
+
+	if pending := clock.Pending(); pending != 0 {
+		t.Errorf("expected the scheduled heal to be cancelled, got %d timers", pending)
+	}
+}
+
+func TestHealsAreDisabledWithoutHealer(t *testing.T) {
+	td := newTestDriver()
+	clock := fake.NewClock()
+	td.clock = clock
+	td.config.HealSchedule = "03:30"
+	td.api.Join(td.newSwitch(2))
+
+	td.zwaveAPI = nil
+	td.scheduleHeal()
+	td.setAPI(basicAPI{td.api})
+
+	if pending := clock.Pending(); pending != 0 {
+		t.Errorf("expected no heal to be scheduled, got %d timers", pending)
+	}
+	if err := td.HealNetwork(); err == nil {
+		t.Error("expected heals to fail without heal commands")
+	}
+	td.scheduleHeal()
+	if pending := clock.Pending(); pending != 0 {
+		t.Errorf("expected no heal to be scheduled once the API is known, got %d timers", pending)
+	}
+}
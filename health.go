@@ -0,0 +1,62 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ninjasphere/driver-go-zwave/utils"
+)
+
+const (
+	lastSeenCheckInterval = time.Minute
+)
+
+//
+// Without the codes of NOTIFICATION notifications, the driver is not told
+// when OpenZWave finds a node dead or alive, so instead it periodically takes
+// the nodes that have not been heard from for the offlineTimeout to be
+// offline.
+//
+type lastSeenCheck struct {
+	sync.Mutex
+	started bool        // true once notification codes were found to be unavailable
+	timer   utils.Timer // the next check, nil once stopped
+}
+
+//
+// Called for each NOTIFICATION notification that has no code. The first
+// such notification is logged and starts the last seen checks.
+//
+func (d *ZDriver) notificationCodesUnavailable() {
+	d.lastSeen.Lock()
+	defer d.lastSeen.Unlock()
+	if d.lastSeen.started {
+		return
+	}
+	d.lastSeen.started = true
+
+	d.Log.Warningf("Notification codes are unavailable in this version of go-openzwave - nodes not heard from for %d seconds are taken to be offline", d.configuration().OfflineTimeout)
+	d.lastSeen.timer = d.Clock().AfterFunc(lastSeenCheckInterval, d.checkLastSeen)
+}
+
+func (d *ZDriver) checkLastSeen() {
+	timeout := time.Duration(d.configuration().OfflineTimeout) * time.Second
+	for _, device := range d.allDevices() {
+		device.CheckLastSeen(timeout)
+	}
+
+	d.lastSeen.Lock()
+	defer d.lastSeen.Unlock()
+	if d.lastSeen.timer != nil {
+		d.lastSeen.timer = d.Clock().AfterFunc(lastSeenCheckInterval, d.checkLastSeen)
+	}
+}
+
+func (d *ZDriver) stopLastSeenChecks() {
+	d.lastSeen.Lock()
+	defer d.lastSeen.Unlock()
+	if d.lastSeen.timer != nil {
+		d.lastSeen.timer.Stop()
+		d.lastSeen.timer = nil
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ninjasphere/go-openzwave"
+	"github.com/ninjasphere/go-openzwave/CC"
+
+	"github.com/ninjasphere/driver-go-zwave/fake"
+)
+
+func TestNodeNotHeardFromGoesOffline(t *testing.T) {
+	td := newTestDriver()
+	clock := fake.NewClock()
+	td.clock = clock
+
+	node := td.newSwitch(2)
+	td.api.Join(node)
+	device := td.deviceOf(node)
+
+	td.api.NotifyWithoutCode(node)
+	td.api.NotifyWithoutCode(node)
+	if clock.Pending() != 1 {
+		t.Fatalf("expected one last seen check to be scheduled, got %d", clock.Pending())
+	}
+
+	node.GetValueWithId(openzwave.ValueID{CC.SWITCH_BINARY, 1, 0}).(*fake.Value).Emit(true)
+	clock.Advance(59 * time.Minute)
+	if !device.Health().Online {
+		t.Fatal("expected the node to be online within the offline timeout")
+	}
+
+	clock.Advance(time.Minute)
+	if device.Health().Online {
+		t.Error("expected the node to be offline once not heard from for the offline timeout")
+	}
+
+	pending := clock.Pending()
+	td.stopLastSeenChecks()
+	if clock.Pending() != pending-1 {
+		t.Error("expected the last seen checks to stop")
+	}
+}
+
+func TestNodeIsNotCheckedWhenCodesAreAvailable(t *testing.T) {
+	td := newTestDriver()
+	clock := fake.NewClock()
+	td.clock = clock
+
+	node := td.newSwitch(2)
+	td.api.Join(node)
+	td.api.NotifyCode(0, node)
+
+	if clock.Pending() != 0 {
+		t.Errorf("expected no last seen checks, got %d", clock.Pending())
+	}
+}
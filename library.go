@@ -1,10 +1,16 @@
 package main
 
 import (
+	"sync"
+	"time"
+
 	"github.com/ninjasphere/go-openzwave"
+	"github.com/ninjasphere/go-openzwave/CC"
 	"github.com/ninjasphere/go-openzwave/MF"
 
 	"github.com/ninjasphere/driver-go-zwave/devices/aeon"
+	"github.com/ninjasphere/driver-go-zwave/devices/common"
+	"github.com/ninjasphere/driver-go-zwave/devices/generic"
 	"github.com/ninjasphere/driver-go-zwave/spi"
 )
 
@@ -14,44 +20,231 @@ type libraryT map[openzwave.ProductId]NinjaDeviceFactory
 var (
 	library libraryT = make(map[openzwave.ProductId]NinjaDeviceFactory)
 
-	AEON_MULTISENSOR = openzwave.ProductId{MF.AEON_LABS, "0005"}
-	AEON_ILLUMINATOR = openzwave.ProductId{MF.AEON_LABS, "0008"}
+	AEON_MULTISENSOR         = openzwave.ProductId{MF.AEON_LABS, "0005"}
+	AEON_SMART_ENERGY_SWITCH = openzwave.ProductId{MF.AEON_LABS, "0006"}
+	AEON_ILLUMINATOR         = openzwave.ProductId{MF.AEON_LABS, "0008"}
+	AEON_SMART_SWITCH_6      = openzwave.ProductId{MF.AEON_LABS, "0060"}
+	RADIO_THERMOSTAT_CT100   = openzwave.ProductId{"0098", "0107"} // Radio Thermostat Company of America
+
+	//
+	// The adapters tried, in order, for products that are not in the library.
+	// The first adapter whose value is supported by the node, and whose
+	// predicate (if any) accepts the node, is used.
+	//
+	fallbacks = []struct {
+		id      openzwave.ValueID
+		factory NinjaDeviceFactory
+		when    func(node openzwave.Node) bool
+	}{
+		{openzwave.ValueID{CC.DOOR_LOCK, 1, 0}, common.LockFactory, nil},
+		{openzwave.ValueID{CC.THERMOSTAT_MODE, 1, 0}, common.ThermostatFactory, nil},
+		{openzwave.ValueID{CC.THERMOSTAT_SETPOINT, 1, 1}, common.ThermostatFactory, nil},
+		{openzwave.ValueID{CC.SWITCH_MULTILEVEL, 1, 0}, common.ShadeFactory, common.IsShade},
+		{openzwave.ValueID{CC.SWITCH_BINARY, 1, 0}, common.SwitchFactory, nil},
+		{openzwave.ValueID{CC.SENSOR_BINARY, 1, 0}, common.BinarySensorFactory, isNotMultisensor},
+		{openzwave.ValueID{CC.ALARM, 1, 1}, common.BinarySensorFactory, isNotMultisensor},
+	}
 )
 
+//
+// Binary sensors that also report luminance or humidity are left to the
+// generic adapter, which exports all their sensors.
+//
+func isNotMultisensor(node openzwave.Node) bool {
+	return !generic.Supports(node, openzwave.ValueID{CC.SENSOR_MULTILEVEL, 1, 3}) &&
+		!generic.Supports(node, openzwave.ValueID{CC.SENSOR_MULTILEVEL, 1, 5})
+}
+
 type Library interface {
 	GetDeviceFactory(id openzwave.ProductId) NinjaDeviceFactory
+	AddMapping(mapping *generic.Mapping) bool
 }
 
 func GetLibrary() Library {
 	if len(library) == 0 {
 		library[AEON_MULTISENSOR] = aeon.MultiSensorFactory
 		library[AEON_ILLUMINATOR] = aeon.IlluminatorFactory
+		library[AEON_SMART_ENERGY_SWITCH] = common.SwitchFactory
+		library[AEON_SMART_SWITCH_6] = common.SwitchFactory
+		library[RADIO_THERMOSTAT_CT100] = common.ThermostatFactory
 	}
 	return &library
 }
 
-type unsupportedDevice struct {
-}
-
 func (lib *libraryT) GetDeviceFactory(id openzwave.ProductId) NinjaDeviceFactory {
 	factory, ok := (*lib)[id]
 	if ok {
 		return factory
 	} else {
-		return func(spi spi.Driver, node openzwave.Node) openzwave.Device {
-			return &unsupportedDevice{}
+		return fallbackFactory
+	}
+}
+
+//
+// A fallbackDevice is the device of a product that is not in the library.
+// The adapter is chosen from the values supported by the node, falling back
+// to the generic adapter, but the values of a node are only known once the
+// node has been added, so the choice is deferred until then. From then on
+// the device delegates to the chosen adapter, which exports itself.
+//
+type fallbackDevice struct {
+	spi.Device
+
+	mutex   sync.Mutex    // guards adapter
+	adapter spi.Patchable // nil until the node has been added
+}
+
+func fallbackFactory(driver spi.Driver, node openzwave.Node) openzwave.Device {
+	device := &fallbackDevice{}
+	device.Init(driver, node)
+	return device
+}
+
+//
+// Answer the chosen adapter, or nil if the node has not been added yet.
+//
+func (device *fallbackDevice) delegate() spi.Patchable {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	return device.adapter
+}
+
+func (device *fallbackDevice) choose() spi.Patchable {
+	node := device.Node()
+
+	var chosen openzwave.Device
+	for _, fallback := range fallbacks {
+		if generic.Supports(node, fallback.id) && (fallback.when == nil || fallback.when(node)) {
+			chosen = fallback.factory(device.Driver, node)
+			break
 		}
 	}
+	if chosen == nil {
+		chosen = generic.Fallback(device.Driver, node)
+	}
+
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	device.adapter = chosen.(spi.Patchable)
+	return device.adapter
+}
+
+func (device *fallbackDevice) NodeAdded() {
+	adapter := device.delegate()
+	if adapter == nil {
+		adapter = device.choose()
+	}
+	adapter.NodeAdded()
+}
+
+func (device *fallbackDevice) NodeChanged() {
+	if adapter := device.delegate(); adapter != nil {
+		adapter.NodeChanged()
+	}
+}
+
+func (device *fallbackDevice) NodeRemoved() {
+	if adapter := device.delegate(); adapter != nil {
+		adapter.NodeRemoved()
+	}
+	device.Detach()
 }
 
-func (*unsupportedDevice) NodeAdded() {
+func (device *fallbackDevice) ValueChanged(v openzwave.Value) {
+	if adapter := device.delegate(); adapter != nil {
+		adapter.ValueChanged(v)
+	}
 }
 
-func (*unsupportedDevice) NodeChanged() {
+func (device *fallbackDevice) Patch(node openzwave.Node) {
+	device.Device.Patch(node)
+	if adapter := device.delegate(); adapter != nil {
+		adapter.Patch(node)
+	}
 }
 
-func (*unsupportedDevice) ValueChanged(openzwave.Value) {
+func (device *fallbackDevice) Republish() {
+	if adapter := device.delegate(); adapter != nil {
+		adapter.Republish()
+	}
+}
+
+func (device *fallbackDevice) WakeUp() {
+	if adapter := device.delegate(); adapter != nil {
+		adapter.WakeUp()
+	} else {
+		device.Device.WakeUp()
+	}
+}
+
+func (device *fallbackDevice) Heard() {
+	if adapter := device.delegate(); adapter != nil {
+		adapter.Heard()
+	} else {
+		device.Device.Heard()
+	}
+}
+
+func (device *fallbackDevice) Sleeps() bool {
+	if adapter := device.delegate(); adapter != nil {
+		return adapter.Sleeps()
+	}
+	return device.Device.Sleeps()
+}
+
+func (device *fallbackDevice) QueueState() *spi.QueueState {
+	if adapter := device.delegate(); adapter != nil {
+		return adapter.QueueState()
+	}
+	return device.Device.QueueState()
+}
+
+func (device *fallbackDevice) Seen() {
+	if adapter := device.delegate(); adapter != nil {
+		adapter.Seen()
+	} else {
+		device.Device.Seen()
+	}
+}
+
+func (device *fallbackDevice) CommandFailed() {
+	if adapter := device.delegate(); adapter != nil {
+		adapter.CommandFailed()
+	} else {
+		device.Device.CommandFailed()
+	}
+}
+
+func (device *fallbackDevice) SetOnline(online bool) {
+	if adapter := device.delegate(); adapter != nil {
+		adapter.SetOnline(online)
+	} else {
+		device.Device.SetOnline(online)
+	}
+}
+
+func (device *fallbackDevice) CheckLastSeen(timeout time.Duration) {
+	if adapter := device.delegate(); adapter != nil {
+		adapter.CheckLastSeen(timeout)
+	} else {
+		device.Device.CheckLastSeen(timeout)
+	}
+}
+
+func (device *fallbackDevice) Health() *spi.HealthState {
+	if adapter := device.delegate(); adapter != nil {
+		return adapter.Health()
+	}
+	return device.Device.Health()
 }
 
-func (*unsupportedDevice) NodeRemoved() {
+//
+// Add a declarative device mapping to the library. A mapping replaces
+// any existing factory for the same product. Answers true if it did so.
+//
+func (lib *libraryT) AddMapping(mapping *generic.Mapping) bool {
+	id := mapping.GetProductId()
+	_, replaced := (*lib)[id]
+	(*lib)[id] = generic.Factory(mapping)
+	return replaced
 }
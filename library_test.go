@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ninjasphere/go-openzwave"
+	"github.com/ninjasphere/go-openzwave/CC"
+
+	"github.com/ninjasphere/driver-go-zwave/fake"
+)
+
+var (
+	unknownProduct     = openzwave.ProductId{"0115", "0100"}
+	unknownDescription = openzwave.ProductDescription{"Z-Wave.Me", "Weather Sensor", "Sensor"}
+)
+
+func TestUnknownProductIsProbed(t *testing.T) {
+	td := newTestDriver()
+	node := td.api.NewNode(3, unknownProduct, unknownDescription)
+	temperature := node.AddValue(openzwave.ValueID{CC.SENSOR_MULTILEVEL, 1, 1}, 21.5)
+	node.AddValue(openzwave.ValueID{CC.SENSOR_MULTILEVEL, 1, 5}, 40.0)
+	node.AddValue(openzwave.ValueID{CC.BATTERY, 1, 0}, uint8(90))
+
+	td.api.Join(node)
+
+	devices := td.conn.Devices()
+	if len(devices) != 1 {
+		t.Fatalf("expected the probed device to be exported, got %d devices", len(devices))
+	}
+	if thingType := (*devices[0].GetDeviceInfo().Signatures)["ninja:thingType"]; thingType != "sensor" {
+		t.Errorf("expected thing type sensor, got %s", thingType)
+	}
+	for _, id := range []string{"temperature", "humidity", "battery", "health"} {
+		if td.conn.Channel(devices[0], id) == nil {
+			t.Errorf("expected the %s channel to be exported", id)
+		}
+	}
+	for _, id := range []string{"on-off", "brightness", "motion", "power"} {
+		if td.conn.Channel(devices[0], id) != nil {
+			t.Errorf("expected no %s channel for values the node does not have", id)
+		}
+	}
+
+	temperature.Emit(22.0)
+	states := td.conn.States("temperature")
+	if len(states) != 1 || states[0] != 22.0 {
+		t.Errorf("expected the temperature to be reported, got %v", states)
+	}
+}
+
+//
+// The values of a node may not be known when its device is built, so the
+// adapter of an unknown product is only chosen once the node is added.
+//
+func TestUnknownProductAdapterIsChosenWhenNodeAdded(t *testing.T) {
+	td := newTestDriver()
+	td.api = fake.NewAPI(func(api openzwave.API, node openzwave.Node) openzwave.Device {
+		device := td.newDevice(api, node)
+		node.(*fake.Node).AddValue(openzwave.ValueID{CC.DOOR_LOCK, 1, 0}, false)
+		return device
+	}, td.notified)
+	node := td.api.NewNode(4, unknownProduct, openzwave.ProductDescription{"Acme", "Deadbolt", "Lock"})
+
+	td.api.Join(node)
+
+	devices := td.conn.Devices()
+	if len(devices) != 1 {
+		t.Fatalf("expected the lock to be exported, got %d devices", len(devices))
+	}
+	if thingType := (*devices[0].GetDeviceInfo().Signatures)["ninja:thingType"]; thingType != "lock" {
+		t.Errorf("expected thing type lock, got %s", thingType)
+	}
+	if td.conn.Channel(devices[0], "lock") == nil {
+		t.Error("expected the lock channel to be exported")
+	}
+
+	td.api.Leave(4)
+	td.api.Join(td.api.NewNode(4, unknownProduct, openzwave.ProductDescription{"Acme", "Deadbolt", "Lock"}))
+	if devices := td.conn.Devices(); len(devices) != 1 {
+		t.Errorf("expected the re-added lock to reuse its device, got %d devices", len(devices))
+	}
+}
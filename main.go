@@ -2,19 +2,33 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 )
 
 func main() {
 
 	var debug bool
+	var record string
+	var replay string
+	var realtime bool
 
 	flagset := flag.NewFlagSet("driver-go-zwave", flag.ContinueOnError)
 	flagset.BoolVar(&debug, "debug", false, "Enable debugging")
+	flagset.StringVar(&record, "record", "", "Record OpenZWave notifications to the specified trace file")
+	flagset.StringVar(&replay, "replay", "", "Replay the specified trace file instead of running OpenZWave")
+	flagset.BoolVar(&realtime, "realtime", false, "Preserve the recorded timing when replaying a trace file")
 	flagset.Parse(os.Args[1:])
 
-	zwaveDriver, err := newZWaveDriver(debug)
+	var zwaveDriver *ZDriver
+	var err error
+	if replay != "" {
+		zwaveDriver, err = newReplayDriver(debug, replay, realtime)
+	} else {
+		zwaveDriver, err = newZWaveDriver(debug, record)
+	}
 	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 	os.Exit(zwaveDriver.wait())
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ninjasphere/driver-go-zwave/spi"
+)
+
+const (
+	networkCachePattern = "zwcfg_0x*.xml"
+)
+
+//
+// networkCache is the part of the network cache that OpenZWave writes to
+// the user data directory that identifies the nodes of the network.
+//
+type networkCache struct {
+	HomeID string `xml:"home_id,attr"`
+	Nodes  []struct {
+		ID           uint8 `xml:"id,attr"`
+		Manufacturer struct {
+			ID      string `xml:"id,attr"`
+			Product struct {
+				ID string `xml:"id,attr"`
+			} `xml:"Product"`
+		} `xml:"Manufacturer"`
+	} `xml:"Node"`
+}
+
+//
+// If no node has been named yet, but OpenZWave already knows about nodes,
+// the driver has been upgraded from a version that exported v0 natural
+// ids, so the nodes keep their v0 ids.
+//
+func (d *ZDriver) preserveNames(userDataDir string) error {
+	if len(d.names.Mapping()) != 0 {
+		return nil
+	}
+
+	legacyIDs, err := cachedNodes(userDataDir)
+	if err != nil {
+		d.Log.Errorf("Unable to read the OpenZWave network cache: %s", err)
+		return err
+	}
+	if len(legacyIDs) == 0 {
+		return nil
+	}
+
+	d.Log.Infof("Keeping the %s natural ids of %d nodes paired by an earlier version", spi.LegacyNaturalIDType, len(legacyIDs))
+	return d.names.Preserve(legacyIDs)
+}
+
+//
+// Answer the v0 natural ids of the nodes in the network caches found in
+// the user data directory.
+//
+func cachedNodes(userDataDir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(userDataDir, networkCachePattern))
+	if err != nil {
+		return nil, err
+	}
+
+	legacyIDs := []string{}
+	for _, path := range paths {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		cache := &networkCache{}
+		if err := xml.Unmarshal(buf, cache); err != nil {
+			return nil, fmt.Errorf("Unable to parse %s: %s", path, err)
+		}
+		homeId, err := strconv.ParseUint(strings.TrimPrefix(cache.HomeID, "0x"), 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid home id %s in %s", cache.HomeID, path)
+		}
+
+		for _, node := range cache.Nodes {
+			legacyIDs = append(legacyIDs, spi.FormatLegacyNaturalID(uint32(homeId), node.ID, node.Manufacturer.ID, node.Manufacturer.Product.ID))
+		}
+	}
+	return legacyIDs, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ninjasphere/driver-go-zwave/spi"
+)
+
+func TestCachedNodesAreRead(t *testing.T) {
+	legacyIDs, err := cachedNodes(filepath.Join("testdata", "network"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"0184c3d2:001:0086:0001", "0184c3d2:002:0086:0006"}
+	if !reflect.DeepEqual(legacyIDs, expected) {
+		t.Errorf("expected %v, got %v", expected, legacyIDs)
+	}
+}
+
+func TestExcludedNodeKeepsNaturalID(t *testing.T) {
+	td := newTestDriver()
+	first := td.api.Join(td.newSwitch(2)).(spi.Patchable)
+
+	if err := td.StartExclusion(); err != nil {
+		t.Fatal(err)
+	}
+	td.api.Exclude(2)
+	td.expect(t, exclusion, "completed")
+
+	if err := td.StartInclusion(); err != nil {
+		t.Fatal(err)
+	}
+	second := td.api.Include(td.newSwitch(5)).(spi.Patchable)
+	td.expect(t, inclusion, "completed")
+
+	if second != first {
+		t.Error("expected the re-included node to be patched into the existing device")
+	}
+	if devices := td.conn.Devices(); len(devices) != 1 {
+		t.Errorf("expected the device to be exported once, got %d devices", len(devices))
+	}
+}
+
+func TestUnnamedNodeIsNotExported(t *testing.T) {
+	dir, err := ioutil.TempDir("", "names")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := spi.LoadNames(filepath.Join(dir, namesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(dir)
+	td := newTestDriver()
+	td.names = names
+
+	device := td.api.Join(td.newSwitch(2)).(spi.Patchable)
+	if id := device.GetDeviceInfo().NaturalID; id != "" {
+		t.Errorf("expected no natural id when the names cannot be saved, got %s", id)
+	}
+	if devices := td.conn.Devices(); len(devices) != 0 {
+		t.Errorf("expected the device not to be exported, got %d devices", len(devices))
+	}
+}
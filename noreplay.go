@@ -0,0 +1,18 @@
+//go:build !replay
+// +build !replay
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/ninjasphere/go-openzwave"
+)
+
+func newReplayDriver(debug bool, trace string, realtime bool) (*ZDriver, error) {
+	return nil, fmt.Errorf("Unable to replay %s - the driver was built without the replay tag", trace)
+}
+
+func (d *ZDriver) startReplay(callback openzwave.NotificationCallback) error {
+	return fmt.Errorf("Unable to replay %s - the driver was built without the replay tag", d.replay)
+}
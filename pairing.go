@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ninjasphere/driver-go-zwave/spi"
+)
+
+const (
+	inclusion = "inclusion"
+	exclusion = "exclusion"
+)
+
+//
+// PairingEvent reports the progress of an inclusion or exclusion. It is
+// sent as an "inclusion" or "exclusion" event.
+//
+type PairingEvent struct {
+	State string `json:"state"`
+	Node  uint8  `json:"node,omitempty"` // the node added or removed, for the node-added and node-removed states
+}
+
+//
+// pairing tracks the inclusion, exclusion or heal that is in progress, if
+// any. At most one controller command can be in progress at a time.
+//
+type pairing struct {
+	sync.Mutex
+	mode  string // inclusion, exclusion, heal, neighbor-update or "" if idle
+	timer *time.Timer
+}
+
+//
+// Put the controller into inclusion mode, so that the next device that
+// is put into pairing mode is added to the network. Inclusion is secure
+// if a network key has been configured. Inclusion is cancelled
+// automatically after the configured pairing timeout.
+//
+func (d *ZDriver) StartInclusion() error {
+	secure := d.configuration().NetworkKey != ""
+	return d.startPairing(inclusion, func(controller spi.Controller, callback func(int)) bool {
+		return controller.AddNode(secure, callback)
+	})
+}
+
+func (d *ZDriver) CancelInclusion() error {
+	return d.cancelPairing(inclusion, "cancelled")
+}
+
+//
+// Put the controller into exclusion mode, so that the next device that
+// is put into pairing mode is removed from the network. Exclusion is
+// cancelled automatically after the configured pairing timeout.
+//
+func (d *ZDriver) StartExclusion() error {
+	return d.startPairing(exclusion, func(controller spi.Controller, callback func(int)) bool {
+		return controller.RemoveNode(callback)
+	})
+}
+
+func (d *ZDriver) CancelExclusion() error {
+	return d.cancelPairing(exclusion, "cancelled")
+}
+
+//
+// The pairing lock is never held while calling the controller, since the
+// controller may report state changes before the command returns.
+//
+func (d *ZDriver) startPairing(mode string, start func(spi.Controller, func(int)) bool) error {
+	controller, ok := spi.GetController(d.ZWave())
+	if !ok {
+		return fmt.Errorf("Unable to start %s - this version of go-openzwave does not expose controller commands", mode)
+	}
+
+	if err := d.claimController(mode); err != nil {
+		return err
+	}
+
+	callback := func(state int) {
+		d.pairingStateChanged(mode, spi.ControllerState(state))
+	}
+	if !start(controller, callback) {
+		d.releaseController(mode)
+		return fmt.Errorf("Unable to start %s - the controller rejected the command", mode)
+	}
+
+	timeout := time.Duration(d.configuration().PairingTimeout) * time.Second
+	d.pairing.Lock()
+	if d.pairing.mode == mode {
+		d.pairing.timer = time.AfterFunc(timeout, func() {
+			d.cancelPairing(mode, "timeout")
+		})
+	}
+	d.pairing.Unlock()
+
+	d.Log.Infof("Started %s", mode)
+	d.SendEvent(mode, &PairingEvent{State: "started"})
+	return nil
+}
+
+//
+// Reserve the controller for the specified mode, unless another controller
+// command is in progress.
+//
+func (d *ZDriver) claimController(mode string) error {
+	d.pairing.Lock()
+	defer d.pairing.Unlock()
+	if d.pairing.mode != "" {
+		return fmt.Errorf("Unable to start %s - %s is already in progress", mode, d.pairing.mode)
+	}
+	d.pairing.mode = mode
+	return nil
+}
+
+func (d *ZDriver) releaseController(mode string) {
+	d.pairing.Lock()
+	defer d.pairing.Unlock()
+	if d.pairing.mode == mode {
+		d.pairing.mode = ""
+	}
+}
+
+func (d *ZDriver) cancelPairing(mode string, reason string) error {
+	d.pairing.Lock()
+	if d.pairing.mode != mode {
+		d.pairing.Unlock()
+		return fmt.Errorf("Unable to cancel %s - %s is not in progress", mode, mode)
+	}
+	d.finishPairing(reason)
+	d.pairing.Unlock()
+
+	controller, ok := spi.GetController(d.ZWave())
+	if ok {
+		controller.CancelControllerCommand()
+	}
+	return nil
+}
+
+//
+// Called by the controller as the state of an inclusion or exclusion changes.
+//
+func (d *ZDriver) pairingStateChanged(mode string, state spi.ControllerState) {
+	d.pairing.Lock()
+	defer d.pairing.Unlock()
+
+	if d.pairing.mode != mode {
+		return
+	}
+
+	if state.IsFinal() {
+		d.finishPairing(state.String())
+	} else {
+		d.SendEvent(mode, &PairingEvent{State: state.String()})
+	}
+}
+
+//
+// Called from the notification callback when a node is added to, or
+// removed from, the network. Answer true if the node was added or removed
+// by the inclusion or exclusion in progress.
+//
+func (d *ZDriver) pairingNodeChanged(mode string, state string, node uint8) bool {
+	d.pairing.Lock()
+	defer d.pairing.Unlock()
+
+	if d.pairing.mode != mode {
+		return false
+	}
+	d.SendEvent(mode, &PairingEvent{State: state, Node: node})
+	return true
+}
+
+//
+// called with the pairing lock held
+//
+func (d *ZDriver) finishPairing(state string) {
+	mode := d.pairing.mode
+
+	if d.pairing.timer != nil {
+		d.pairing.timer.Stop()
+		d.pairing.timer = nil
+	}
+	d.pairing.mode = ""
+
+	d.Log.Infof("Finished %s: %s", mode, state)
+	d.SendEvent(mode, &PairingEvent{State: state})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ninjasphere/go-openzwave"
+)
+
+func TestInclusionCompletes(t *testing.T) {
+	td := newTestDriver()
+
+	if err := td.StartInclusion(); err != nil {
+		t.Fatal(err)
+	}
+	td.expect(t, inclusion, "started")
+
+	if td.api.Include(td.newSwitch(2)) == nil {
+		t.Fatal("expected the controller to be waiting for a node")
+	}
+	event := td.expect(t, inclusion, "node-added")
+	if node := event.payload.(*PairingEvent).Node; node != 2 {
+		t.Errorf("expected node 2 to be added, got %d", node)
+	}
+	td.expect(t, inclusion, "completed")
+
+	if devices := td.conn.Devices(); len(devices) != 1 {
+		t.Errorf("expected the new device to be exported, got %d devices", len(devices))
+	}
+	if err := td.StartExclusion(); err != nil {
+		t.Errorf("expected the controller to be released after inclusion: %s", err)
+	}
+}
+
+func TestInclusionTimesOut(t *testing.T) {
+	td := newTestDriver()
+	td.config.PairingTimeout = 1
+
+	if err := td.StartInclusion(); err != nil {
+		t.Fatal(err)
+	}
+	td.expect(t, inclusion, "timeout")
+
+	if td.api.Busy() {
+		t.Error("expected the inclusion to be cancelled at the controller")
+	}
+	if err := td.CancelInclusion(); err == nil {
+		t.Error("expected no inclusion to be in progress after the timeout")
+	}
+}
+
+func TestExclusionForgetsNode(t *testing.T) {
+	td := newTestDriver()
+	td.api.Join(td.newSwitch(2))
+
+	if err := td.StartExclusion(); err != nil {
+		t.Fatal(err)
+	}
+	if !td.api.Exclude(2) {
+		t.Fatal("expected the controller to be waiting for a node")
+	}
+	td.expect(t, exclusion, "node-removed")
+	td.expect(t, exclusion, "completed")
+
+	if nodes := td.nodeIds(); len(nodes) != 0 {
+		t.Errorf("expected the removed node to be forgotten, got %v", nodes)
+	}
+}
+
+func TestControllerRunsOneCommand(t *testing.T) {
+	td := newTestDriver()
+
+	if err := td.StartInclusion(); err != nil {
+		t.Fatal(err)
+	}
+	if err := td.StartExclusion(); err == nil {
+		t.Error("expected exclusion to be refused while inclusion is in progress")
+	}
+	if err := td.CancelInclusion(); err != nil {
+		t.Fatal(err)
+	}
+	td.expect(t, inclusion, "cancelled")
+	if td.api.Busy() {
+		t.Error("expected the inclusion to be cancelled at the controller")
+	}
+}
+
+//
+// basicAPI hides the controller commands of the fake API, as the released
+// versions of go-openzwave do.
+//
+type basicAPI struct {
+	openzwave.API
+}
+
+func TestPairingIsDisabledWithoutController(t *testing.T) {
+	td := newTestDriver()
+	td.zwaveAPI = basicAPI{td.api}
+
+	if err := td.StartInclusion(); err == nil {
+		t.Error("expected inclusion to fail without controller commands")
+	}
+	if err := td.StartExclusion(); err == nil {
+		t.Error("expected exclusion to fail without controller commands")
+	}
+	if err := td.CancelInclusion(); err == nil {
+		t.Error("expected no inclusion to be in progress")
+	}
+}
@@ -0,0 +1,80 @@
+//go:build replay
+// +build replay
+
+package main
+
+import (
+	"github.com/ninjasphere/go-ninja/logger"
+	"github.com/ninjasphere/go-openzwave"
+
+	"github.com/ninjasphere/driver-go-zwave/fake"
+	"github.com/ninjasphere/driver-go-zwave/spi"
+	"github.com/ninjasphere/driver-go-zwave/trace/replay"
+	"github.com/ninjasphere/driver-go-zwave/utils"
+)
+
+//
+// Create a driver that replays a recorded trace of notifications instead of
+// running OpenZWave. The driver does not connect to ninja: devices are
+// exported to a fake connection and the events they send are logged.
+//
+// Replays are only supported by drivers built with the replay tag, so that
+// the fakes are not part of the production driver.
+//
+func newReplayDriver(debug bool, trace string, realtime bool) (*ZDriver, error) {
+
+	driver := &ZDriver{
+		config:   defaultConfig(),
+		debug:    debug,
+		zwaveAPI: nil,
+		exit:     make(chan int, 1),
+		devices:  make(map[string]spi.Patchable),
+		byNode:   make(map[uint8]spi.Patchable),
+		replay:   trace,
+		realtime: realtime,
+		clock:    utils.SystemClock,
+	}
+
+	driver.Info = info
+	driver.Log = logger.GetLogger(driverName)
+	driver.SetEventHandler(func(event string, payload interface{}) error {
+		driver.Log.Infof("driver event: %s %v", event, payload)
+		return nil
+	})
+
+	conn := fake.NewConnection()
+	conn.SetListener(func(event fake.Event) {
+		driver.Log.Infof("device event: %s %s %s %v", event.Device.GetDeviceInfo().NaturalID, event.Channel, event.Event, event.Payload)
+	})
+	driver.conn = conn
+
+	// the OpenZWave configuration is not used by a replay
+	config := defaultConfig()
+	config.ConfigDir = config.UserDataDir
+
+	err := driver.Start(config)
+	if err != nil {
+		return nil, err
+	}
+	return driver, nil
+}
+
+//
+// Replay the trace through the fake API instead of running OpenZWave. The
+// driver exits when the replay is complete.
+//
+func (d *ZDriver) startReplay(callback openzwave.NotificationCallback) error {
+	api := fake.NewAPI(d.newDevice, callback)
+	d.setAPI(api)
+	go func() {
+		err := replay.Run(d.replay, api, d.realtime)
+		if err != nil {
+			d.Log.Errorf("Failed to replay trace file %s: %s", d.replay, err)
+			d.exit <- 1
+			return
+		}
+		d.Log.Infof("Replay of %s complete", d.replay)
+		d.exit <- 0
+	}()
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ninjasphere/go-ninja/bus"
+	"github.com/ninjasphere/go-openzwave"
+	"github.com/ninjasphere/go-openzwave/CC"
+
+	"github.com/ninjasphere/driver-go-zwave/fake"
+)
+
+func TestReconnectRepublishes(t *testing.T) {
+	td := newTestDriver()
+	td.watchReconnects(td.conn)
+
+	node := td.newSwitch(2)
+	td.api.Join(node)
+	node.GetValueWithId(openzwave.ValueID{CC.SWITCH_BINARY, 1, 0}).(*fake.Value).Emit(true)
+
+	td.conn.Reconnect()
+
+	if states := td.conn.States("on-off"); !reflect.DeepEqual(states, []interface{}{true, true}) {
+		t.Errorf("expected the switch state to be sent again on reconnection, got %v", states)
+	}
+}
+
+//
+// testBus stands in for the MQTT client of a *ninja.Connection, reporting
+// the reconnections of a fake connection.
+//
+type testBus struct {
+	bus.Bus
+	conn *fake.Connection
+}
+
+func (b *testBus) OnConnect(callback func()) {
+	b.conn.OnConnect(callback)
+}
+
+type mqttTestConnection struct {
+	client bus.Bus
+}
+
+func (conn *mqttTestConnection) GetMqttClient() bus.Bus {
+	return conn.client
+}
+
+func TestReconnectOfMqttClientRepublishes(t *testing.T) {
+	td := newTestDriver()
+	td.watchReconnects(&mqttTestConnection{&testBus{conn: td.conn}})
+
+	node := td.newSwitch(2)
+	td.api.Join(node)
+	node.GetValueWithId(openzwave.ValueID{CC.SWITCH_BINARY, 1, 0}).(*fake.Value).Emit(true)
+
+	td.conn.Reconnect()
+
+	if states := td.conn.States("on-off"); !reflect.DeepEqual(states, []interface{}{true, true}) {
+		t.Errorf("expected the switch state to be sent again when the MQTT client reconnects, got %v", states)
+	}
+}
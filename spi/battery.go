@@ -0,0 +1,300 @@
+package spi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	BatteryLowWarning = 0xFF // the level reported by a device to warn that its battery is low
+
+	maxBatterySamples   = 200
+	minSampleInterval   = 6 * time.Hour       // an unchanged level is sampled at most this often
+	minTrendSpan        = 24 * time.Hour      // the span of samples needed to estimate the trend
+	maxTrendAge         = 90 * 24 * time.Hour // samples older than this are ignored by the trend
+	batteryReplacedRise = 20                  // a rise of this many percent means the battery has been replaced
+)
+
+//
+// BatterySample is a battery level reported by a device.
+//
+type BatterySample struct {
+	Time  time.Time `json:"time"`
+	Level uint8     `json:"level"`
+}
+
+//
+// BatteryStatus is the payload of "status" and "low" events on battery
+// channels. Level is omitted if the device has only sent the low battery
+// warning. DaysRemaining is estimated from the discharge trend, once there
+// is enough history to estimate it.
+//
+type BatteryStatus struct {
+	Level         *float64 `json:"level,omitempty"`
+	Low           bool     `json:"low"`
+	Threshold     int      `json:"threshold"`
+	DaysRemaining *float64 `json:"daysRemaining,omitempty"`
+}
+
+//
+// Batteries is the persistent battery history of each device, by natural
+// id, together with the driver-wide low battery threshold. A battery that
+// has been reported low stays low until it is replaced, so a device that
+// alternates the low battery warning with levels above the threshold is
+// only reported low once.
+//
+type Batteries struct {
+	path      string
+	history   map[string][]BatterySample
+	low       map[string]bool // devices whose low battery has been reported
+	threshold int
+	mutex     sync.Mutex
+}
+
+//
+// The format of the battery file. Files written before the low flags were
+// persisted hold only the history.
+//
+type batteriesFile struct {
+	History map[string][]BatterySample `json:"history"`
+	Low     map[string]bool            `json:"low,omitempty"`
+}
+
+//
+// Load the battery history stored at the specified path. A missing file is
+// not an error - it is created when the first sample is recorded.
+//
+func LoadBatteries(path string, threshold int) (*Batteries, error) {
+	batteries := NewBatteries(threshold)
+	batteries.path = path
+
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return batteries, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Unable to read battery history from %s: %s", path, err)
+	}
+
+	file := batteriesFile{}
+	err = json.Unmarshal(buf, &file)
+	if err == nil && file.History == nil {
+		err = json.Unmarshal(buf, &file.History)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse battery history from %s: %s", path, err)
+	}
+	if file.History != nil {
+		batteries.history = file.History
+	}
+	if file.Low != nil {
+		batteries.low = file.Low
+	}
+	return batteries, nil
+}
+
+//
+// Create an empty battery history that is not persisted.
+//
+func NewBatteries(threshold int) *Batteries {
+	return &Batteries{
+		history:   make(map[string][]BatterySample),
+		low:       make(map[string]bool),
+		threshold: threshold,
+	}
+}
+
+//
+// Record a level reported by the device and answer the resulting status of
+// the battery, and whether the battery has just become low, so that a low
+// battery is reported once rather than with every report.
+//
+func (batteries *Batteries) Record(naturalID string, level uint8, threshold int, now time.Time) (*BatteryStatus, bool, error) {
+	batteries.mutex.Lock()
+	defer batteries.mutex.Unlock()
+
+	var err error
+	samples := batteries.history[naturalID]
+	status := &BatteryStatus{Threshold: threshold}
+	wasLow := batteries.low[naturalID]
+
+	if level == BatteryLowWarning {
+		if len(samples) > 0 {
+			last := float64(samples[len(samples)-1].Level)
+			status.Level = &last
+		}
+		status.Low = true
+	} else {
+		replaced := isReplacement(samples, level, threshold)
+		if batteries.isNew(samples, level, now) {
+			samples, err = batteries.add(naturalID, samples, level, now)
+		}
+		current := float64(level)
+		status.Level = &current
+		status.Low = int(level) <= threshold || (wasLow && !replaced)
+	}
+
+	status.DaysRemaining = estimateDaysRemaining(samples, now)
+
+	if status.Low != wasLow {
+		if status.Low {
+			batteries.low[naturalID] = true
+		} else {
+			delete(batteries.low, naturalID)
+		}
+		if saveErr := batteries.save(); err == nil {
+			err = saveErr
+		}
+	}
+	return status, status.Low && !wasLow, err
+}
+
+//
+// Answer true if the level means the battery has been replaced: it is well
+// above the last level recorded or, if none has been, the threshold.
+//
+func isReplacement(samples []BatterySample, level uint8, threshold int) bool {
+	last := threshold
+	if len(samples) > 0 {
+		last = int(samples[len(samples)-1].Level)
+	}
+	return int(level) >= last+batteryReplacedRise
+}
+
+//
+// Answer true if the level is worth recording: it has changed, or it has
+// not been recorded for a while.
+//
+func (batteries *Batteries) isNew(samples []BatterySample, level uint8, now time.Time) bool {
+	if len(samples) == 0 {
+		return true
+	}
+	last := samples[len(samples)-1]
+	return level != last.Level || now.Sub(last.Time) >= minSampleInterval
+}
+
+func (batteries *Batteries) add(naturalID string, samples []BatterySample, level uint8, now time.Time) ([]BatterySample, error) {
+	if len(samples) > 0 && int(level) >= int(samples[len(samples)-1].Level)+batteryReplacedRise {
+		// the battery has been replaced, so the old trend no longer applies
+		samples = nil
+	}
+	samples = append(samples, BatterySample{now, level})
+	if len(samples) > maxBatterySamples {
+		samples = samples[len(samples)-maxBatterySamples:]
+	}
+	batteries.history[naturalID] = samples
+	return samples, batteries.save()
+}
+
+//
+// Answer the recorded history of the device's battery.
+//
+func (batteries *Batteries) History(naturalID string) []BatterySample {
+	batteries.mutex.Lock()
+	defer batteries.mutex.Unlock()
+	return append([]BatterySample{}, batteries.history[naturalID]...)
+}
+
+func (batteries *Batteries) Threshold() int {
+	return batteries.threshold
+}
+
+//
+// Estimate the days until the battery is flat from the least squares fit
+// of level against time over the recent samples. Answer nil if the samples
+// span too short a time, or the battery is not discharging.
+//
+func estimateDaysRemaining(samples []BatterySample, now time.Time) *float64 {
+	recent := []BatterySample{}
+	for _, sample := range samples {
+		if now.Sub(sample.Time) <= maxTrendAge {
+			recent = append(recent, sample)
+		}
+	}
+	if len(recent) < 2 || recent[len(recent)-1].Time.Sub(recent[0].Time) < minTrendSpan {
+		return nil
+	}
+
+	origin := recent[0].Time
+	n := float64(len(recent))
+	var sumX, sumY, sumXY, sumXX float64
+	for _, sample := range recent {
+		x := sample.Time.Sub(origin).Hours() / 24
+		y := float64(sample.Level)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return nil
+	}
+	slope := (n*sumXY - sumX*sumY) / denominator // percent per day
+	if slope >= 0 {
+		return nil
+	}
+
+	last := recent[len(recent)-1]
+	days := float64(last.Level)/-slope - now.Sub(last.Time).Hours()/24
+	if days < 0 {
+		days = 0
+	}
+	return &days
+}
+
+func (batteries *Batteries) save() error {
+	if batteries.path == "" {
+		return nil
+	}
+
+	buf, err := json.MarshalIndent(&batteriesFile{batteries.history, batteries.low}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := batteries.path + ".tmp"
+	err = ioutil.WriteFile(tmp, buf, 0644)
+	if err != nil {
+		return fmt.Errorf("Unable to save battery history to %s: %s", batteries.path, err)
+	}
+	return os.Rename(tmp, batteries.path)
+}
+
+//
+// Record a battery level reported by the device and answer true if the level
+// should be reported on the battery channel, or false if the report is the
+// low battery warning, which carries no level. A "status" event, and a "low" event when
+// the battery first becomes low, are sent with the specified function,
+// which is typically the SendEvent of the device's battery channel.
+//
+func (device *Device) TrackBattery(level uint8, sendEvent func(event string, payload interface{}) error) bool {
+	batteries := device.Driver.Batteries()
+
+	threshold := batteries.Threshold()
+	if config := device.Config(); config.LowBattery != 0 {
+		threshold = config.LowBattery
+	}
+
+	status, alert, err := batteries.Record(device.Info.NaturalID, level, threshold, device.Driver.Clock().Now())
+	if err != nil {
+		device.Driver.ZWave().Logger().Warningf("%s", err)
+	}
+
+	if sendEvent != nil {
+		sendEvent("status", status)
+		if alert {
+			sendEvent("low", status)
+		}
+	}
+	if alert && status.Level != nil {
+		device.Driver.ZWave().Logger().Warningf("battery of %v is low: %v%%", device.Node(), *status.Level)
+	} else if alert {
+		device.Driver.ZWave().Logger().Warningf("battery of %v is low", device.Node())
+	}
+
+	return level != BatteryLowWarning
+}
@@ -0,0 +1,107 @@
+package spi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var batteryEpoch = time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func TestLowWarningWithoutHistoryHasNoLevel(t *testing.T) {
+	batteries := NewBatteries(20)
+
+	status, alert, err := batteries.Record("abc", BatteryLowWarning, 20, batteryEpoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !alert || !status.Low {
+		t.Error("expected the low battery warning to be reported as low")
+	}
+	if status.Level != nil {
+		t.Errorf("expected no level, got %v", *status.Level)
+	}
+}
+
+func TestLowBatteryIsReportedOnce(t *testing.T) {
+	batteries := NewBatteries(20)
+	alerts := 0
+	for i, level := range []uint8{50, BatteryLowWarning, 50, BatteryLowWarning, 45, 15} {
+		status, alert, err := batteries.Record("abc", level, 20, batteryEpoch.Add(time.Duration(i)*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 && !status.Low {
+			t.Errorf("expected the battery to stay low at report %d", i)
+		}
+		if alert {
+			alerts++
+		}
+	}
+	if alerts != 1 {
+		t.Errorf("expected the low battery to be reported once, got %d", alerts)
+	}
+}
+
+func TestReplacedBatteryIsNoLongerLow(t *testing.T) {
+	batteries := NewBatteries(20)
+	batteries.Record("abc", BatteryLowWarning, 20, batteryEpoch)
+
+	status, _, _ := batteries.Record("abc", 100, 20, batteryEpoch.Add(time.Hour))
+	if status.Low {
+		t.Error("expected a replaced battery not to be low")
+	}
+	if _, alert, _ := batteries.Record("abc", BatteryLowWarning, 20, batteryEpoch.Add(2*time.Hour)); !alert {
+		t.Error("expected the replaced battery to be reported low again")
+	}
+}
+
+func TestLowBatteryIsPersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "batteries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "zwave-batteries.json")
+
+	batteries, err := LoadBatteries(path, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batteries.Record("abc", 50, 20, batteryEpoch)
+	batteries.Record("abc", BatteryLowWarning, 20, batteryEpoch.Add(time.Hour))
+
+	loaded, err := LoadBatteries(path, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.History("abc")) != 1 {
+		t.Errorf("expected the history to be reloaded, got %v", loaded.History("abc"))
+	}
+	if _, alert, _ := loaded.Record("abc", BatteryLowWarning, 20, batteryEpoch.Add(2*time.Hour)); alert {
+		t.Error("expected the low battery not to be reported again after a restart")
+	}
+}
+
+func TestBatteryHistoryWithoutLowFlagsIsLoaded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "batteries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "zwave-batteries.json")
+	legacy := `{"abc": [{"time": "2015-06-01T00:00:00Z", "level": 50}]}`
+	if err := ioutil.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	batteries, err := LoadBatteries(path, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history := batteries.History("abc"); len(history) != 1 || history[0].Level != 50 {
+		t.Errorf("expected the history to be loaded, got %v", history)
+	}
+}
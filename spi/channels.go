@@ -0,0 +1,38 @@
+package spi
+
+//
+// A StateChannel is a ninja channel for protocols that have no channel
+// type in go-ninja. Adapters embed it in channel types that implement the
+// methods of the protocol.
+//
+type StateChannel struct {
+	protocol  string
+	sendEvent func(event string, payload interface{}) error
+}
+
+func NewStateChannel(protocol string) *StateChannel {
+	return &StateChannel{protocol: protocol}
+}
+
+func (channel *StateChannel) GetProtocol() string {
+	return channel.protocol
+}
+
+func (channel *StateChannel) SetEventHandler(sendEvent func(event string, payload interface{}) error) {
+	channel.sendEvent = sendEvent
+}
+
+//
+// Send an event on the channel. Events sent before the channel has been
+// exported are discarded.
+//
+func (channel *StateChannel) SendEvent(event string, payload interface{}) error {
+	if channel.sendEvent == nil {
+		return nil
+	}
+	return channel.sendEvent(event, payload)
+}
+
+func (channel *StateChannel) SendState(state interface{}) error {
+	return channel.SendEvent("state", state)
+}
@@ -0,0 +1,103 @@
+package spi
+
+import (
+	"github.com/ninjasphere/go-openzwave"
+)
+
+//
+// DeviceConfig holds the configuration overrides for a single device. The
+// overrides are keyed by the natural id of the device in the driver's configuration.
+//
+type DeviceConfig struct {
+	Name                 string `json:"name,omitempty"`                 // overrides the product name reported by the device
+	Polling              *bool  `json:"polling,omitempty"`              // if false, disables polling of the device's values
+	SensorType           string `json:"sensorType,omitempty"`           // the type of a binary sensor: contact, motion, water, smoke or co
+	CalibrationParameter uint8  `json:"calibrationParameter,omitempty"` // the configuration parameter that starts a shade's calibration
+	MotionTimeout        int    `json:"motionTimeout,omitempty"`        // seconds after the last motion at which the driver clears motion, 0 to wait for the sensor
+	LowBattery           int    `json:"lowBattery,omitempty"`           // the battery level, in percent, at or below which the battery is low
+	WakeUpInterval       int    `json:"wakeUpInterval,omitempty"`       // the desired seconds between the wake ups of a sleeping device, 0 to leave it unchanged
+
+	Reporting  map[string]*ReportingPolicy `json:"reporting,omitempty"`  // reporting policies, by channel id
+	Parameters map[string]int              `json:"parameters,omitempty"` // desired values of configuration parameters, by parameter number
+}
+
+//
+// Answer a copy of the configuration, which may be nil, updated by the
+// specified function. The maps of the copy are not shared with the
+// configuration, so the update may modify them.
+//
+func (config *DeviceConfig) Update(update func(config *DeviceConfig)) *DeviceConfig {
+	updated := &DeviceConfig{}
+	if config != nil {
+		*updated = *config
+	}
+	updated.Reporting = make(map[string]*ReportingPolicy, len(updated.Reporting))
+	for channel, policy := range config.reporting() {
+		updated.Reporting[channel] = policy
+	}
+	updated.Parameters = make(map[string]int, len(updated.Parameters))
+	for index, value := range config.parameters() {
+		updated.Parameters[index] = value
+	}
+	update(updated)
+	return updated
+}
+
+func (config *DeviceConfig) reporting() map[string]*ReportingPolicy {
+	if config == nil {
+		return nil
+	}
+	return config.Reporting
+}
+
+func (config *DeviceConfig) parameters() map[string]int {
+	if config == nil {
+		return nil
+	}
+	return config.Parameters
+}
+
+//
+// Answer the configuration overrides for the device, or an empty configuration
+// if there are none.
+//
+func (device *Device) Config() *DeviceConfig {
+	config := device.Driver.DeviceConfig(device.Info.NaturalID)
+	if config == nil {
+		return &DeviceConfig{}
+	}
+	return config
+}
+
+//
+// Enable polling of the specified values, unless polling has been
+// disabled for the device by configuration. Polling a node that sleeps
+// would only fill the node's queue in OpenZWave, so the values of such a
+// node are refreshed each time it wakes up instead.
+//
+func (device *Device) Poll(ids ...openzwave.ValueID) {
+	polling := device.Config().Polling
+	enabled := polling == nil || *polling
+	sleeps := device.Sleeps()
+	for _, id := range ids {
+		device.Node().GetValueWithId(id).SetPollingState(enabled && !sleeps)
+	}
+	if enabled && sleeps {
+		device.mutex.Lock()
+		defer device.mutex.Unlock()
+		for _, id := range ids {
+			if !containsId(device.polled, id) {
+				device.polled = append(device.polled, id)
+			}
+		}
+	}
+}
+
+func containsId(ids []openzwave.ValueID, id openzwave.ValueID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
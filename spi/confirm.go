@@ -0,0 +1,52 @@
+package spi
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ninjasphere/go-openzwave"
+)
+
+//
+// Wait until the node confirms a value the adapter has set: the value is
+// refreshed until confirmed answers true for the refreshed value, or until
+// the timeout. While this waits, the adapter's ValueChanged must pass
+// changes to the value to Refreshed rather than reporting them, and the
+// adapter reports the confirmed value itself. A timeout counts as a failed
+// command in the health of the device.
+//
+func (device *Device) Confirm(val openzwave.Value, timeout time.Duration, confirmed func(val openzwave.Value) bool) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	// loop until timeout or until a refresh yields the expected value
+
+	for {
+		if !val.Refresh() {
+			return fmt.Errorf("refresh failed")
+		}
+		select {
+		case <-timer.C:
+			device.CommandFailed()
+			return fmt.Errorf("timeout")
+		case <-device.refreshed:
+			if confirmed(val) {
+				return nil
+			}
+		}
+	}
+}
+
+//
+// Called by the adapter's ValueChanged with each change to a value that is
+// set with Confirm. Answers true if Confirm is waiting for the change, in
+// which case the adapter should not report it.
+//
+func (device *Device) Refreshed() bool {
+	select {
+	case device.refreshed <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,111 @@
+package spi
+
+import (
+	"github.com/ninjasphere/go-openzwave"
+)
+
+//
+// The states reported by the controller while a controller command
+// is in progress. These mirror OpenZWave's Driver::ControllerState.
+//
+type ControllerState int
+
+const (
+	ControllerNormal ControllerState = iota
+	ControllerStarting
+	ControllerCancel
+	ControllerError
+	ControllerWaiting
+	ControllerSleeping
+	ControllerInProgress
+	ControllerCompleted
+	ControllerFailed
+	ControllerNodeOK
+	ControllerNodeFailed
+)
+
+var controllerStateNames = []string{
+	"normal",
+	"starting",
+	"cancelled",
+	"error",
+	"waiting",
+	"sleeping",
+	"in-progress",
+	"completed",
+	"failed",
+	"node-ok",
+	"node-failed",
+}
+
+func (state ControllerState) String() string {
+	if state < 0 || int(state) >= len(controllerStateNames) {
+		return "unknown"
+	}
+	return controllerStateNames[state]
+}
+
+//
+// Answer true if the controller command has finished, one way or another.
+//
+func (state ControllerState) IsFinal() bool {
+	switch state {
+	case ControllerCancel, ControllerError, ControllerCompleted, ControllerFailed, ControllerNodeOK, ControllerNodeFailed:
+		return true
+	default:
+		return false
+	}
+}
+
+//
+// Controller is implemented by openzwave.API implementations that
+// support controller commands. Each command answers false if the command
+// could not be started, otherwise the callback is called with OpenZWave's
+// controller state, which converts to a ControllerState, as the state of
+// the command changes.
+//
+type Controller interface {
+	AddNode(secure bool, callback func(state int)) bool
+	RemoveNode(callback func(state int)) bool
+	CancelControllerCommand() bool
+}
+
+//
+// Answer the controller interface of the API, if it has one.
+//
+func GetController(api openzwave.API) (Controller, bool) {
+	if api == nil {
+		return nil, false
+	}
+	controller, ok := api.(Controller)
+	return controller, ok
+}
+
+//
+// Healer is implemented by openzwave.API implementations that support
+// network heals. HealNetworkNode asks the node to rediscover its neighbours,
+// updates the controller's routing table and, if returnRoutes is true,
+// assigns new return routes to the node. RequestNodeNeighborUpdate only
+// asks the node to rediscover its neighbours. Each answers false if the
+// command could not be started, otherwise the callback is called with
+// OpenZWave's controller state, as for Controller commands, as the state of
+// the command changes. GetNodeNeighbors answers the neighbours of
+// the node in the controller's routing table.
+//
+type Healer interface {
+	HealNetworkNode(node uint8, returnRoutes bool, callback func(state int)) bool
+	RequestNodeNeighborUpdate(node uint8, callback func(state int)) bool
+	GetNodeNeighbors(node uint8) []uint8
+	CancelControllerCommand() bool
+}
+
+//
+// Answer the healer interface of the API, if it has one.
+//
+func GetHealer(api openzwave.API) (Healer, bool) {
+	if api == nil {
+		return nil, false
+	}
+	healer, ok := api.(Healer)
+	return healer, ok
+}
@@ -2,17 +2,63 @@ package spi
 
 import (
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/ninjasphere/go-ninja/api"
 	"github.com/ninjasphere/go-ninja/model"
 	"github.com/ninjasphere/go-openzwave"
+
+	"github.com/ninjasphere/driver-go-zwave/utils"
 )
 
 type Device struct {
 	Driver    Driver
 	Info      *model.Device
 	SendEvent func(event string, payload interface{}) error
-	Node      openzwave.Node
+
+	exported  bool          // true once the device has been exported to the RPC layer
+	refreshed chan struct{} // hands refreshed values to Confirm
+	flushing  sync.Mutex    // serializes writing desired values and running queued commands
+
+	mutex      sync.Mutex           // guards the fields below
+	node       openzwave.Node       // the current incarnation of the node, replaced by Patch
+	removed    bool                 // true while the node is absent from the zwave network
+	reporters  []utils.Repeater     // the emitters built by Reporter
+	parameters map[uint8]*Parameter // the parameters defined by the adapter, by index
+	written    map[string]int       // values written since the node was added or last woke up, by command key
+	queue      []*command           // commands waiting for a sleeping node to wake up
+	polled     []openzwave.ValueID  // values refreshed each time a sleeping node wakes up
+	lastWakeUp *time.Time
+
+	health      *HealthChannel // nil until the adapter exports it
+	offline     bool           // true while OpenZWave reports the node dead, or it has not been heard from for too long
+	lastSeen    *time.Time
+	failures    int // commands sent to the node that have timed out
+	lastFailure *time.Time
+}
+
+//
+// A Patchable device is a "patch" proxy for a zwave node. The RPC layer
+// does not allow a ninja device to be unexported, so rather than building
+// a new device when a node is re-added to the network, the driver patches
+// the new node into the device that was exported for the earlier
+// incarnation of the node.
+//
+type Patchable interface {
+	openzwave.Device
+	ninja.Device
+	Patch(node openzwave.Node)
+	Republish()
+	WakeUp()
+	Heard()
+	Sleeps() bool
+	QueueState() *QueueState
+	Seen()
+	CommandFailed()
+	SetOnline(online bool)
+	CheckLastSeen(timeout time.Duration)
+	Health() *HealthState
 }
 
 func (device *Device) GetDriver() ninja.Driver {
@@ -29,8 +75,9 @@ func (device *Device) SetEventHandler(sendEvent func(event string, payload inter
 
 func (device *Device) Init(driver Driver, node openzwave.Node) {
 	device.Driver = driver
-	device.Node = node
+	device.node = node
 	device.Info = &model.Device{}
+	device.refreshed = make(chan struct{})
 
 	productId := node.GetProductId()
 	productDescription := node.GetProductDescription()
@@ -46,23 +93,111 @@ func (device *Device) Init(driver Driver, node openzwave.Node) {
 	device.Info.Signatures = &sigs
 
 	//
-	// This naming scheme won't survive reconfigurations of the network
-	// where the network has two devices of the same type.
+	// The v0 naming scheme won't survive reconfigurations of the network
+	// where the network has two devices of the same type, so the v0 id is
+	// only used to look up the natural id of the node.
 	//
-	// So, we will need to investigate generating a unique token that
-	// gets stored in the device. When this scheme is implemented we
-	// should update the naming scheme used here from v0 to v1.
-	//
-	device.Info.NaturalIDType = "ninja.zwave.v0"
-	device.Info.NaturalID = fmt.Sprintf(
-		"%08x:%03d:%s:%s",
-		node.GetHomeId(),
-		node.GetId(),
-		productId.ManufacturerId,
-		productId.ProductId)
+	legacyID := LegacyNaturalID(node)
+	sigs["zwave:legacyNaturalID"] = legacyID
+
+	naturalID, naturalIDType, err := driver.Names().Identify(legacyID)
+	if err != nil {
+		//
+		// Exporting the node under its v0 id instead would change the id
+		// of the device from one run to the next, so the device is left
+		// without a natural id and Export refuses it.
+		//
+		driver.ZWave().Logger().Errorf("unable to name node %v, it will not be exported: %s", node, err)
+	}
+	device.Info.NaturalIDType = naturalIDType
+	device.Info.NaturalID = naturalID
 
 	device.Info.Name = &productDescription.ProductName
+	if config := driver.DeviceConfig(device.Info.NaturalID); config != nil && config.Name != "" {
+		device.Info.Name = &config.Name
+	}
 
 	// initialize brightness from the current level
 
 }
+
+//
+// Replace the node underlying the device with a new incarnation of the
+// node and mark the device as present again.
+//
+func (device *Device) Patch(node openzwave.Node) {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	device.node = node
+	device.removed = false
+	device.written = nil
+}
+
+//
+// Answer the node underlying the device. The node is replaced when the
+// node is re-added to the network, so callers should not hold on to it.
+//
+func (device *Device) Node() openzwave.Node {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	return device.node
+}
+
+//
+// Mark the device as absent from the network. The device remains exported
+// but requests from the ninja side will fail until the node is patched back in.
+//
+func (device *Device) Detach() {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	device.removed = true
+}
+
+//
+// Answer an error if the node underlying the device has been removed.
+//
+func (device *Device) CheckPresent() error {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	if device.removed {
+		return fmt.Errorf("Node %s has been removed from the network", device.Info.NaturalID)
+	}
+	return nil
+}
+
+//
+// Answer true if the device and its channels have already been exported to the RPC layer.
+//
+func (device *Device) IsExported() bool {
+	return device.exported
+}
+
+func (device *Device) SetExported() {
+	device.exported = true
+}
+
+//
+// Export the adapter, which embeds this device, and the device's health
+// channel to the RPC layer, and answer true if the adapter was exported.
+// Adapters export their own channels once this succeeds.
+//
+func (device *Device) Export(adapter ninja.Device) bool {
+	node := device.Node()
+	logger := device.Driver.ZWave().Logger()
+	conn := device.Driver.Connection()
+
+	if device.Info.NaturalID == "" {
+		logger.Errorf("not exporting node %v - it has no natural id", node)
+		return false
+	}
+	if err := conn.ExportDevice(adapter); err != nil {
+		logger.Infof("failed to export node: %v as device: %s", node, err)
+		return false
+	}
+	device.SetExported()
+
+	if err := conn.ExportChannel(adapter, device.HealthChannel(), "health"); err != nil {
+		logger.Infof("failed to export health channel for %v: %s", node, err)
+	}
+	return true
+}
@@ -0,0 +1,154 @@
+package spi
+
+import (
+	"time"
+)
+
+//
+// HealthState is the payload of "state" events on the health channel and
+// of the "offline" and "online" events of a device. LastSeen is the time the
+// node was last heard from, and FailedCommands is the number of commands
+// sent to the node that have timed out since the driver started.
+//
+type HealthState struct {
+	Online         bool       `json:"online"`
+	LastSeen       *time.Time `json:"lastSeen,omitempty"`
+	FailedCommands int        `json:"failedCommands"`
+	LastFailure    *time.Time `json:"lastFailure,omitempty"`
+}
+
+//
+// The health channel reports the health of the node underlying a device.
+// Its state is sent when the node goes offline or comes back, and when a
+// command sent to the node fails.
+//
+type HealthChannel struct {
+	*StateChannel
+	device *Device
+}
+
+func (channel *HealthChannel) Get() (*HealthState, error) {
+	return channel.device.Health(), nil
+}
+
+//
+// Answer the health channel of the device, which adapters export with their
+// other channels.
+//
+func (device *Device) HealthChannel() *HealthChannel {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	if device.health == nil {
+		device.health = &HealthChannel{NewStateChannel("zwave-health"), device}
+	}
+	return device.health
+}
+
+func (device *Device) Health() *HealthState {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	return device.healthState()
+}
+
+func (device *Device) healthState() *HealthState {
+	return &HealthState{
+		Online:         !device.offline,
+		LastSeen:       device.lastSeen,
+		FailedCommands: device.failures,
+		LastFailure:    device.lastFailure,
+	}
+}
+
+//
+// Called when the node has been heard from. A node that is heard from is
+// alive, even if it had been reported dead.
+//
+func (device *Device) Seen() {
+	now := device.Driver.Clock().Now()
+
+	device.mutex.Lock()
+	device.lastSeen = &now
+	device.mutex.Unlock()
+
+	device.SetOnline(true)
+}
+
+//
+// Take the node to be offline if it has not been heard from within the
+// timeout or, if it sleeps, within twice its wake up interval and the
+// timeout. Nodes that have not been heard from since the driver started,
+// and sleeping nodes whose wake up interval is unknown, are left alone.
+//
+func (device *Device) CheckLastSeen(timeout time.Duration) {
+	if device.CheckPresent() != nil {
+		return
+	}
+	if device.Sleeps() {
+		interval, ok := device.WakeUpInterval()
+		if !ok {
+			return
+		}
+		timeout += 2 * interval
+	}
+
+	device.mutex.Lock()
+	lastSeen := device.lastSeen
+	device.mutex.Unlock()
+
+	if lastSeen != nil && device.Driver.Clock().Now().Sub(*lastSeen) >= timeout {
+		device.SetOnline(false)
+	}
+}
+
+//
+// Called when a command sent to the node has timed out.
+//
+func (device *Device) CommandFailed() {
+	now := device.Driver.Clock().Now()
+
+	device.mutex.Lock()
+	device.failures++
+	device.lastFailure = &now
+	state := device.healthState()
+	device.mutex.Unlock()
+
+	device.sendHealth(state)
+}
+
+//
+// Record whether the node is online, which it is until OpenZWave reports
+// it dead. A device sends an "offline" event when its node goes offline
+// and an "online" event when it comes back.
+//
+func (device *Device) SetOnline(online bool) {
+	device.mutex.Lock()
+	if device.offline == !online {
+		device.mutex.Unlock()
+		return
+	}
+	device.offline = !online
+	state := device.healthState()
+	device.mutex.Unlock()
+
+	event := "online"
+	if !online {
+		event = "offline"
+		device.Driver.ZWave().Logger().Warningf("node %v is offline", device.Node())
+	} else {
+		device.Driver.ZWave().Logger().Infof("node %v is back online", device.Node())
+	}
+	if device.SendEvent != nil {
+		device.SendEvent(event, state)
+	}
+	device.sendHealth(state)
+}
+
+func (device *Device) sendHealth(state *HealthState) {
+	device.mutex.Lock()
+	channel := device.health
+	device.mutex.Unlock()
+
+	if channel != nil {
+		channel.SendState(state)
+	}
+}
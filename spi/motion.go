@@ -0,0 +1,62 @@
+package spi
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ninjasphere/driver-go-zwave/utils"
+)
+
+//
+// MotionPolicy is the default reporting policy of motion channels: repeated
+// reports of motion are reported at most once a second.
+//
+var MotionPolicy = utils.Policy{RepeatAfter: 1 * time.Second}
+
+//
+// A MotionReporter reports motion, and that motion has cleared, on a motion
+// channel. Motion is not republished, since a client would take it for new
+// motion. If the device is configured with a motionTimeout, motion that is
+// not reported again within the timeout is cleared, in case the sensor's own
+// report is missed or the sensor never reports that motion has cleared.
+//
+type MotionReporter struct {
+	emitter utils.Emitter
+	clock   utils.Clock
+	timeout time.Duration
+
+	mutex sync.Mutex // guards timer
+	timer utils.Timer
+}
+
+//
+// Answer a reporter for the specified motion channel. The send function is
+// called with true to report motion and false to report that motion has
+// cleared.
+//
+func (device *Device) MotionReporter(channel string, send func(motion bool)) *MotionReporter {
+	return &MotionReporter{
+		emitter: device.reporter(channel, MotionPolicy, func(next utils.Equatable) {
+			send(next.(*utils.WrappedBool).Unwrap())
+		}),
+		clock:   device.Driver.Clock(),
+		timeout: time.Duration(device.Config().MotionTimeout) * time.Second,
+	}
+}
+
+func (reporter *MotionReporter) Report(motion bool) {
+	reporter.emitter.Emit(utils.WrapBool(motion))
+
+	reporter.mutex.Lock()
+	defer reporter.mutex.Unlock()
+
+	if reporter.timer != nil {
+		reporter.timer.Stop()
+		reporter.timer = nil
+	}
+	if motion && reporter.timeout > 0 {
+		reporter.timer = reporter.clock.AfterFunc(reporter.timeout, func() {
+			reporter.emitter.Emit(utils.WrapBool(false))
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package spi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ninjasphere/go-openzwave"
+)
+
+const (
+	LegacyNaturalIDType = "ninja.zwave.v0"
+	NaturalIDType       = "ninja.zwave.v1"
+)
+
+//
+// Names is the persistent mapping between the v0 natural id of a node
+// (derived from the home id, node id and product id) and the natural id
+// the device of the node is exported with.
+//
+// Nodes that were paired before the mapping existed keep their v0 id (see
+// Preserve), so that things and rules bound to them keep working. Other
+// nodes are given a unique token as their v1 id.
+//
+// The mapping doubles as the migration path from v0 to v1 ids: any client
+// that holds v0 ids can look up the v1 id of the same device here.
+//
+// Since the v0 id contains the home id and the node id, a node that is
+// re-included, or whose network is rebuilt on a replacement controller,
+// comes back with a new v0 id. The node keeps its natural id if it is the
+// only node of its product that was excluded (see Release) or that was on
+// a network with a different home id. Otherwise the driver cannot tell
+// which physical device the node is, so it is given a new token, and the
+// old natural id must be reassigned to the new v0 id (see Assign) for
+// things and rules bound to the device to keep working.
+//
+type Names struct {
+	path    string
+	entries map[string]name // by v0 natural id
+	mutex   sync.Mutex
+}
+
+type name struct {
+	NaturalID     string `json:"naturalId"`
+	NaturalIDType string `json:"naturalIdType"`
+	Released      bool   `json:"released,omitempty"` // true once the node has been excluded
+}
+
+//
+// Answer the v0 natural id of the node.
+//
+func LegacyNaturalID(node openzwave.Node) string {
+	productId := node.GetProductId()
+	return FormatLegacyNaturalID(node.GetHomeId(), node.GetId(), productId.ManufacturerId, productId.ProductId)
+}
+
+func FormatLegacyNaturalID(homeId uint32, nodeId uint8, manufacturerId string, productId string) string {
+	return fmt.Sprintf("%08x:%03d:%s:%s", homeId, nodeId, manufacturerId, productId)
+}
+
+//
+// Load the names stored at the specified path. A missing file is not an
+// error - it is created when the first node is named.
+//
+func LoadNames(path string) (*Names, error) {
+	names := &Names{
+		path:    path,
+		entries: make(map[string]name),
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return names, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Unable to read natural ids from %s: %s", path, err)
+	}
+
+	err = json.Unmarshal(buf, &names.entries)
+	if err == nil {
+		return names, nil
+	}
+
+	// earlier versions stored only the v1 token of each node
+	tokens := make(map[string]string)
+	if json.Unmarshal(buf, &tokens) != nil {
+		return nil, fmt.Errorf("Unable to parse natural ids from %s: %s", path, err)
+	}
+	for legacyID, token := range tokens {
+		names.entries[legacyID] = name{NaturalID: token, NaturalIDType: NaturalIDType}
+	}
+	return names, nil
+}
+
+//
+// Create an empty set of names that is not persisted.
+//
+func NewNames() *Names {
+	return &Names{
+		entries: make(map[string]name),
+	}
+}
+
+//
+// Answer the natural id and natural id type for the specified v0 natural
+// id. A node that has not been seen before takes over the natural id of
+// the node it replaces, if there is exactly one candidate, or is given a
+// new token. Any change is persisted before it is answered, so an error
+// means the node has no natural id that will survive a restart.
+//
+func (names *Names) Identify(legacyID string) (string, string, error) {
+	names.mutex.Lock()
+	defer names.mutex.Unlock()
+
+	entry, ok := names.entries[legacyID]
+	if ok && !entry.Released {
+		return entry.NaturalID, entry.NaturalIDType, nil
+	}
+
+	previous := names.copy()
+	if ok {
+		entry.Released = false
+	} else if replaced, found := names.replaced(legacyID); found {
+		entry = names.entries[replaced]
+		delete(names.entries, replaced)
+	} else {
+		token, err := newToken()
+		if err != nil {
+			return "", "", fmt.Errorf("Unable to generate token for %s: %s", legacyID, err)
+		}
+		entry = name{NaturalID: token, NaturalIDType: NaturalIDType}
+	}
+	names.entries[legacyID] = entry
+
+	if err := names.save(); err != nil {
+		names.entries = previous
+		return "", "", err
+	}
+	return entry.NaturalID, entry.NaturalIDType, nil
+}
+
+//
+// Answer the v0 natural id of the only node of the same product that the
+// node with the specified v0 natural id may be a new incarnation of: a node
+// that has been excluded, or a node of a network with a different home id.
+//
+func (names *Names) replaced(legacyID string) (string, bool) {
+	home, product, ok := splitLegacyID(legacyID)
+	if !ok {
+		return "", false
+	}
+
+	candidates := []string{}
+	for id, entry := range names.entries {
+		otherHome, otherProduct, ok := splitLegacyID(id)
+		if ok && otherProduct == product && (entry.Released || otherHome != home) {
+			candidates = append(candidates, id)
+		}
+	}
+	if len(candidates) != 1 {
+		return "", false
+	}
+	return candidates[0], true
+}
+
+func splitLegacyID(legacyID string) (string, string, bool) {
+	parts := strings.SplitN(legacyID, ":", 4)
+	if len(parts) != 4 {
+		return "", "", false
+	}
+	return parts[0], parts[2] + ":" + parts[3], true
+}
+
+func newToken() (string, error) {
+	buf := make([]byte, 8)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+//
+// Keep the v0 natural ids of nodes that were paired before the mapping
+// existed as their natural ids.
+//
+func (names *Names) Preserve(legacyIDs []string) error {
+	names.mutex.Lock()
+	defer names.mutex.Unlock()
+
+	previous := names.copy()
+	for _, legacyID := range legacyIDs {
+		if _, ok := names.entries[legacyID]; !ok {
+			names.entries[legacyID] = name{NaturalID: legacyID, NaturalIDType: LegacyNaturalIDType}
+		}
+	}
+	if err := names.save(); err != nil {
+		names.entries = previous
+		return err
+	}
+	return nil
+}
+
+//
+// Record that the node with the specified v0 natural id has been excluded,
+// so that the next node of the same product to be included can take over
+// its natural id.
+//
+func (names *Names) Release(legacyID string) error {
+	names.mutex.Lock()
+	defer names.mutex.Unlock()
+
+	entry, ok := names.entries[legacyID]
+	if !ok || entry.Released {
+		return nil
+	}
+	entry.Released = true
+	names.entries[legacyID] = entry
+	if err := names.save(); err != nil {
+		entry.Released = false
+		names.entries[legacyID] = entry
+		return err
+	}
+	return nil
+}
+
+//
+// Assign an existing natural id to a (possibly new) v0 natural id. Any
+// other v0 id that was assigned the same natural id is released.
+//
+func (names *Names) Assign(legacyID string, naturalID string) error {
+	names.mutex.Lock()
+	defer names.mutex.Unlock()
+
+	previous := names.copy()
+	assigned := name{NaturalID: naturalID, NaturalIDType: NaturalIDType}
+	for id, entry := range names.entries {
+		if entry.NaturalID == naturalID {
+			assigned.NaturalIDType = entry.NaturalIDType
+			delete(names.entries, id)
+		}
+	}
+	names.entries[legacyID] = assigned
+	if err := names.save(); err != nil {
+		names.entries = previous
+		return err
+	}
+	return nil
+}
+
+//
+// Answer a copy of the mapping from v0 natural ids to natural ids.
+//
+func (names *Names) Mapping() map[string]string {
+	names.mutex.Lock()
+	defer names.mutex.Unlock()
+
+	mapping := make(map[string]string)
+	for id, entry := range names.entries {
+		mapping[id] = entry.NaturalID
+	}
+	return mapping
+}
+
+func (names *Names) copy() map[string]name {
+	entries := make(map[string]name, len(names.entries))
+	for id, entry := range names.entries {
+		entries[id] = entry
+	}
+	return entries
+}
+
+func (names *Names) save() error {
+	if names.path == "" {
+		return nil
+	}
+
+	buf, err := json.MarshalIndent(names.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := names.path + ".tmp"
+	err = ioutil.WriteFile(tmp, buf, 0644)
+	if err != nil {
+		return fmt.Errorf("Unable to save natural ids to %s: %s", names.path, err)
+	}
+	return os.Rename(tmp, names.path)
+}
@@ -0,0 +1,135 @@
+package spi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTokenIsPersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "names")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "zwave-names.json")
+
+	names, err := LoadNames(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := names.Identify("0184c3d2:002:0086:0064")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadNames(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, _, err := loaded.Identify("0184c3d2:002:0086:0064")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded != token {
+		t.Errorf("expected token %s after reload, got %s", token, reloaded)
+	}
+}
+
+func TestTokenIsRolledBackWhenSaveFails(t *testing.T) {
+	names := &Names{
+		path:    filepath.Join(os.DevNull, "missing", "zwave-names.json"),
+		entries: make(map[string]name),
+	}
+
+	if _, _, err := names.Identify("0184c3d2:002:0086:0064"); err == nil {
+		t.Fatal("expected an error when the names cannot be saved")
+	}
+	if mapping := names.Mapping(); len(mapping) != 0 {
+		t.Errorf("expected the token to be rolled back, got %v", mapping)
+	}
+}
+
+func TestAssignIsRolledBackWhenSaveFails(t *testing.T) {
+	names := NewNames()
+	token, _, _ := names.Identify("0184c3d2:002:0086:0064")
+	names.path = filepath.Join(os.DevNull, "missing", "zwave-names.json")
+
+	if err := names.Assign("0184c3d2:007:0086:0064", token); err == nil {
+		t.Fatal("expected an error when the names cannot be saved")
+	}
+	mapping := names.Mapping()
+	if len(mapping) != 1 || mapping["0184c3d2:002:0086:0064"] != token {
+		t.Errorf("expected the assignment to be rolled back, got %v", mapping)
+	}
+}
+
+func TestEarlierTokensAreLoaded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "names")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "zwave-names.json")
+	ioutil.WriteFile(path, []byte(`{"0184c3d2:002:0086:0064": "5ca1ab1e5ca1ab1e"}`), 0644)
+
+	names, err := LoadNames(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, idType, _ := names.Identify("0184c3d2:002:0086:0064")
+	if id != "5ca1ab1e5ca1ab1e" || idType != NaturalIDType {
+		t.Errorf("expected the earlier token to be kept as a %s id, got %s %s", NaturalIDType, idType, id)
+	}
+}
+
+func TestPreservedNodesKeepLegacyIDs(t *testing.T) {
+	names := NewNames()
+	names.Preserve([]string{"0184c3d2:002:0086:0064"})
+
+	id, idType, _ := names.Identify("0184c3d2:002:0086:0064")
+	if id != "0184c3d2:002:0086:0064" || idType != LegacyNaturalIDType {
+		t.Errorf("expected the %s id to be kept, got %s %s", LegacyNaturalIDType, idType, id)
+	}
+	id, idType, _ = names.Identify("0184c3d2:003:0086:0003")
+	if idType != NaturalIDType || id == "0184c3d2:003:0086:0003" {
+		t.Errorf("expected a new node to be given a %s id, got %s %s", NaturalIDType, idType, id)
+	}
+}
+
+func TestReincludedNodeKeepsNaturalID(t *testing.T) {
+	names := NewNames()
+	token, _, _ := names.Identify("0184c3d2:002:0086:0064")
+	names.Identify("0184c3d2:003:0086:0003")
+
+	names.Release("0184c3d2:002:0086:0064")
+	reincluded, _, _ := names.Identify("0184c3d2:007:0086:0064")
+	if reincluded != token {
+		t.Errorf("expected the excluded node's id %s to be taken over, got %s", token, reincluded)
+	}
+	if mapping := names.Mapping(); len(mapping) != 2 {
+		t.Errorf("expected the old v0 id to be replaced, got %v", mapping)
+	}
+}
+
+func TestReplacementControllerKeepsNaturalIDs(t *testing.T) {
+	names := NewNames()
+	token, _, _ := names.Identify("0184c3d2:002:0086:0064")
+
+	moved, _, _ := names.Identify("cafebabe:002:0086:0064")
+	if moved != token {
+		t.Errorf("expected the node to keep id %s on the new network, got %s", token, moved)
+	}
+}
+
+func TestAmbiguousNodeIsGivenNewNaturalID(t *testing.T) {
+	names := NewNames()
+	first, _, _ := names.Identify("0184c3d2:002:0086:0064")
+	second, _, _ := names.Identify("0184c3d2:003:0086:0064")
+
+	moved, _, _ := names.Identify("cafebabe:002:0086:0064")
+	if moved == first || moved == second {
+		t.Errorf("expected a new id when two nodes of the product could have moved, got %s", moved)
+	}
+}
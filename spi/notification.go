@@ -0,0 +1,38 @@
+package spi
+
+import (
+	"github.com/ninjasphere/go-openzwave"
+)
+
+//
+// The codes of NOTIFICATION notifications, as defined by OpenZWave.
+//
+const (
+	CODE_MSG_COMPLETE = iota
+	CODE_TIMEOUT
+	CODE_NO_OPERATION
+	CODE_AWAKE
+	CODE_SLEEP
+	CODE_DEAD
+	CODE_ALIVE
+)
+
+//
+// Implemented by notifications that carry the code of a NOTIFICATION
+// notification.
+//
+type CodedNotification interface {
+	GetNotificationCode() int
+}
+
+//
+// Answer the code of a NOTIFICATION notification, if the version of
+// go-openzwave in use exposes it.
+//
+func NotificationCode(nt openzwave.Notification) (int, bool) {
+	coded, ok := nt.(CodedNotification)
+	if !ok {
+		return 0, false
+	}
+	return coded.GetNotificationCode(), true
+}
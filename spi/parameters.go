@@ -0,0 +1,336 @@
+package spi
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/ninjasphere/go-openzwave"
+	"github.com/ninjasphere/go-openzwave/CC"
+)
+
+//
+// Parameter describes a CONFIGURATION command class parameter of a product.
+// OpenZWave exposes list parameters by the labels of their values, rather
+// than the values themselves, so the labels of a list parameter are given
+// as its Options.
+//
+type Parameter struct {
+	Index       uint8          `json:"index"`
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Min         int            `json:"min"`
+	Max         int            `json:"max"`
+	Options     map[int]string `json:"options,omitempty"` // the OpenZWave labels of the values of a list parameter
+}
+
+func (parameter *Parameter) Validate(value int) error {
+	if value < parameter.Min || value > parameter.Max {
+		return fmt.Errorf("Invalid value %d for parameter %d (%s): expected %d to %d", value, parameter.Index, parameter.Name, parameter.Min, parameter.Max)
+	}
+	if _, ok := parameter.Options[value]; parameter.Options != nil && !ok {
+		return fmt.Errorf("Invalid value %d for parameter %d (%s): not one of its options", value, parameter.Index, parameter.Name)
+	}
+	return nil
+}
+
+//
+// Answer the string OpenZWave takes for the value of the parameter: the
+// label of the value of a list parameter, otherwise the value in decimal.
+// The parameter may be nil if the adapter does not define it.
+//
+func (parameter *Parameter) format(value int) (string, bool) {
+	if parameter == nil || parameter.Options == nil {
+		return strconv.Itoa(value), true
+	}
+	label, ok := parameter.Options[value]
+	return label, ok
+}
+
+//
+// Answer the value of the parameter for the string reported by OpenZWave.
+//
+func (parameter *Parameter) parse(s string) (int, bool) {
+	if parameter != nil {
+		for value, label := range parameter.Options {
+			if label == s {
+				return value, true
+			}
+		}
+	}
+	i, err := strconv.Atoi(s)
+	return i, err == nil
+}
+
+//
+// ParameterState is the state of a parameter reported by the configuration
+// channel. Value is the value last reported by the device, if known, and
+// Desired is the value that has been configured, if any. The two differ
+// until the device has accepted the desired value, which may not happen
+// until a battery powered device next wakes up.
+//
+type ParameterState struct {
+	*Parameter
+	Value   *int `json:"value,omitempty"`
+	Desired *int `json:"desired,omitempty"`
+	Pending bool `json:"pending"`
+}
+
+func parameterId(index uint8) openzwave.ValueID {
+	return openzwave.ValueID{CC.CONFIGURATION, 1, index}
+}
+
+//
+// Record the definitions of the device's parameters, so that the values of
+// list parameters can be translated to and from their labels.
+//
+func (device *Device) DefineParameters(parameters []*Parameter) {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	device.parameters = make(map[uint8]*Parameter, len(parameters))
+	for _, parameter := range parameters {
+		device.parameters[parameter.Index] = parameter
+	}
+}
+
+//
+// Answer the definition of the parameter with the specified index, or nil
+// if the adapter has not defined it.
+//
+func (device *Device) parameter(index uint8) *Parameter {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	return device.parameters[index]
+}
+
+//
+// Answer the value of the configuration parameter last reported by the
+// device.
+//
+func (device *Device) GetParameter(index uint8) (int, bool) {
+	val := device.Node().GetValueWithId(parameterId(index))
+	if u, ok := val.GetUint8(); ok {
+		return int(u), true
+	}
+	if s, ok := val.GetString(); ok {
+		return device.parameter(index).parse(s)
+	}
+	return 0, false
+}
+
+//
+// Answer the desired value of the configuration parameter, if one has been
+// configured.
+//
+func (device *Device) DesiredParameter(index uint8) (int, bool) {
+	value, ok := device.Config().Parameters[strconv.Itoa(int(index))]
+	return value, ok
+}
+
+//
+// Record the desired value of the configuration parameter in the driver's
+// configuration and try to write it to the device. A device that is asleep
+// accepts the value when it next wakes up. Until the device reports the
+// value, ApplyParameters writes it again each time the node is added or
+// wakes up.
+//
+func (device *Device) SetParameter(index uint8, value int) error {
+	if err := device.CheckPresent(); err != nil {
+		return err
+	}
+
+	err := device.Driver.UpdateDeviceConfig(device.Info.NaturalID, func(config *DeviceConfig) {
+		config.Parameters[strconv.Itoa(int(index))] = value
+	})
+	if err != nil {
+		return err
+	}
+
+	device.flushing.Lock()
+	defer device.flushing.Unlock()
+	return device.writeParameter(index, value)
+}
+
+//
+// Write the desired value of each configuration parameter whose value
+// differs from the value reported by the device, unless the value has
+// already been written since the node was added or last woke up, and answer
+// the indices of the parameters that are still pending.
+//
+func (device *Device) ApplyParameters() []uint8 {
+	device.flushing.Lock()
+	defer device.flushing.Unlock()
+	return device.applyParameters()
+}
+
+//
+// As for ApplyParameters, but called with the flushing lock held, so that a
+// value is not written twice by concurrent wake ups.
+//
+func (device *Device) applyParameters() []uint8 {
+	pending := []uint8{}
+	if device.CheckPresent() != nil {
+		return pending
+	}
+	for _, index := range device.desiredIndices() {
+		desired, _ := device.DesiredParameter(index)
+		if current, ok := device.GetParameter(index); ok && current == desired {
+			continue
+		}
+		pending = append(pending, index)
+		if device.isWritten(parameterKey(index), desired) {
+			continue
+		}
+		device.writeParameter(index, desired)
+	}
+	device.applyWakeUpInterval()
+	return pending
+}
+
+func (device *Device) desiredIndices() []uint8 {
+	indices := []int{}
+	for k := range device.Config().Parameters {
+		index, err := strconv.Atoi(k)
+		if err == nil && index >= 0 && index <= 255 {
+			indices = append(indices, index)
+		}
+	}
+	sort.Ints(indices)
+
+	result := make([]uint8, len(indices))
+	for i, index := range indices {
+		result[i] = uint8(index)
+	}
+	return result
+}
+
+func parameterKey(index uint8) string {
+	return fmt.Sprintf("parameter %d", index)
+}
+
+//
+// Write the parameter now or, if the node sleeps, when it next wakes up.
+// Byte parameters are set as bytes, other parameters by the string
+// OpenZWave takes for them.
+//
+func (device *Device) writeParameter(index uint8, value int) error {
+	key := parameterKey(index)
+	device.setWritten(key, value)
+
+	return device.Queue(key, func() error {
+		val := device.Node().GetValueWithId(parameterId(index))
+		ok := false
+		if _, isByte := val.GetUint8(); isByte {
+			ok = value >= 0 && value <= 255 && val.SetUint8(uint8(value))
+		} else if s, known := device.parameter(index).format(value); known {
+			ok = val.SetString(s)
+		}
+		if !ok {
+			return fmt.Errorf("Failed to set parameter %d to %d - set failed", index, value)
+		}
+		val.Refresh()
+		return nil
+	})
+}
+
+//
+// Note that the value has been written with the specified command key since
+// the node was added or last woke up.
+//
+func (device *Device) setWritten(key string, value int) {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	if device.written == nil {
+		device.written = make(map[string]int)
+	}
+	device.written[key] = value
+}
+
+func (device *Device) isWritten(key string, value int) bool {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	written, ok := device.written[key]
+	return ok && written == value
+}
+
+//
+// Answer the state of each of the specified parameters.
+//
+func (device *Device) ParameterStates(parameters []*Parameter) []*ParameterState {
+	states := make([]*ParameterState, len(parameters))
+	for i, parameter := range parameters {
+		state := &ParameterState{Parameter: parameter}
+		if value, ok := device.GetParameter(parameter.Index); ok {
+			state.Value = &value
+		}
+		if desired, ok := device.DesiredParameter(parameter.Index); ok {
+			state.Desired = &desired
+			state.Pending = state.Value == nil || *state.Value != desired
+		}
+		states[i] = state
+	}
+	return states
+}
+
+//
+// The configuration channel reads and writes the CONFIGURATION parameters
+// of a device that are known to the device's adapter, and reports their
+// state with "state" events.
+//
+type ConfigurationChannel struct {
+	*StateChannel
+	device     *Device
+	parameters []*Parameter
+}
+
+//
+// Create a configuration channel for the specified parameters, which are
+// also defined on the device.
+//
+func NewConfigurationChannel(device *Device, parameters []*Parameter) *ConfigurationChannel {
+	device.DefineParameters(parameters)
+	return &ConfigurationChannel{NewStateChannel("zwave-configuration"), device, parameters}
+}
+
+func (channel *ConfigurationChannel) Get() ([]*ParameterState, error) {
+	if err := channel.device.CheckPresent(); err != nil {
+		return nil, err
+	}
+	return channel.device.ParameterStates(channel.parameters), nil
+}
+
+func (channel *ConfigurationChannel) Set(index int, value int) error {
+	parameter := channel.Parameter(index)
+	if parameter == nil {
+		return fmt.Errorf("Unknown parameter %d", index)
+	}
+	if err := parameter.Validate(value); err != nil {
+		return err
+	}
+	err := channel.device.SetParameter(parameter.Index, value)
+	channel.SendParameters()
+	return err
+}
+
+//
+// Set the number of seconds between the wake ups of a device that sleeps.
+//
+func (channel *ConfigurationChannel) SetWakeUpInterval(seconds int) error {
+	return channel.device.SetWakeUpInterval(seconds)
+}
+
+//
+// Answer the definition of the parameter with the specified index, or nil.
+//
+func (channel *ConfigurationChannel) Parameter(index int) *Parameter {
+	for _, parameter := range channel.parameters {
+		if int(parameter.Index) == index {
+			return parameter
+		}
+	}
+	return nil
+}
+
+func (channel *ConfigurationChannel) SendParameters() {
+	channel.SendState(channel.device.ParameterStates(channel.parameters))
+}
@@ -0,0 +1,244 @@
+package spi
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ninjasphere/go-openzwave"
+	"github.com/ninjasphere/go-openzwave/CC"
+)
+
+var (
+	wake_up_interval = openzwave.ValueID{CC.WAKE_UP, 1, 0}
+)
+
+//
+// QueueState is the payload of "queue" events sent by a device that sleeps,
+// and describes the commands waiting for the device to wake up.
+//
+type QueueState struct {
+	Depth      int        `json:"depth"`
+	Pending    []string   `json:"pending"`
+	LastWakeUp *time.Time `json:"lastWakeUp,omitempty"`
+}
+
+type command struct {
+	key string
+	run func() error
+}
+
+//
+// Answer true if the node is a battery device that sleeps between wake ups,
+// which is the case if it supports the WAKE_UP command class.
+//
+func (device *Device) Sleeps() bool {
+	val := device.Node().GetValueWithId(wake_up_interval)
+	if _, ok := val.GetString(); ok {
+		return true
+	}
+	if _, ok := val.GetUint8(); ok {
+		return true
+	}
+	_, ok := val.GetFloat()
+	return ok
+}
+
+//
+// Answer the interval at which a sleeping node wakes up, if it is known.
+//
+func (device *Device) WakeUpInterval() (time.Duration, bool) {
+	val := device.Node().GetValueWithId(wake_up_interval)
+	if f, ok := val.GetFloat(); ok {
+		return seconds(f), true
+	}
+	if s, ok := val.GetString(); ok {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return seconds(f), true
+		}
+	}
+	return 0, false
+}
+
+const (
+	maxWakeUpInterval = 0xffffff           // seconds, the largest 3 byte WAKE_UP interval
+	wakeUpKey         = "wake up interval" // the key of the queued command that writes it
+)
+
+//
+// Record the desired wake up interval of a device that sleeps in the
+// driver's configuration and write it to the device when it next wakes up.
+// As for configuration parameters, the interval is written again each time
+// the node is added or wakes up until the device reports it.
+//
+func (device *Device) SetWakeUpInterval(seconds int) error {
+	if err := device.CheckPresent(); err != nil {
+		return err
+	}
+	if !device.Sleeps() {
+		return fmt.Errorf("Failed to set wake up interval - %v does not sleep", device.Node())
+	}
+	if seconds < 1 || seconds > maxWakeUpInterval {
+		return fmt.Errorf("Invalid wake up interval %d: expected 1 to %d seconds", seconds, maxWakeUpInterval)
+	}
+
+	err := device.Driver.UpdateDeviceConfig(device.Info.NaturalID, func(config *DeviceConfig) {
+		config.WakeUpInterval = seconds
+	})
+	if err != nil {
+		return err
+	}
+
+	device.flushing.Lock()
+	defer device.flushing.Unlock()
+	return device.writeWakeUpInterval(seconds)
+}
+
+//
+// Write the desired wake up interval, if there is one that the device has
+// not reported and that has not been written since the node was added or
+// last woke up.
+//
+func (device *Device) applyWakeUpInterval() {
+	desired := device.Config().WakeUpInterval
+	if desired == 0 || !device.Sleeps() {
+		return
+	}
+	if current, ok := device.WakeUpInterval(); ok && current == seconds(float64(desired)) {
+		return
+	}
+	if device.isWritten(wakeUpKey, desired) {
+		return
+	}
+	device.writeWakeUpInterval(desired)
+}
+
+func (device *Device) writeWakeUpInterval(interval int) error {
+	device.setWritten(wakeUpKey, interval)
+
+	return device.Queue(wakeUpKey, func() error {
+		val := device.Node().GetValueWithId(wake_up_interval)
+		ok := false
+		if _, isFloat := val.GetFloat(); isFloat {
+			ok = val.SetFloat(float64(interval))
+		} else {
+			ok = val.SetString(strconv.Itoa(interval))
+		}
+		if !ok {
+			return fmt.Errorf("Failed to set wake up interval to %d - set failed", interval)
+		}
+		val.Refresh()
+		return nil
+	})
+}
+
+//
+// Run the command now, unless the device sleeps, in which case the command
+// is queued until the device next wakes up. A queued command replaces a
+// command with the same key that is still waiting, so only the latest set of
+// a value is sent. Errors from queued commands are logged, since the caller
+// has moved on by the time they are run.
+//
+func (device *Device) Queue(key string, run func() error) error {
+	if !device.Sleeps() {
+		return run()
+	}
+
+	device.mutex.Lock()
+	replaced := false
+	for _, queued := range device.queue {
+		if queued.key == key {
+			queued.run = run
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		device.queue = append(device.queue, &command{key, run})
+	}
+	device.mutex.Unlock()
+
+	device.sendQueueState()
+	return nil
+}
+
+//
+// Called when the node reports that it has woken up: the configuration
+// parameters that have not been accepted are written again, the queued
+// commands are run in order and the polled values are refreshed.
+//
+func (device *Device) WakeUp() {
+	now := device.Driver.Clock().Now()
+
+	device.flushing.Lock()
+	device.mutex.Lock()
+	device.lastWakeUp = &now
+	device.written = nil
+	polled := append([]openzwave.ValueID{}, device.polled...)
+	device.mutex.Unlock()
+
+	device.applyParameters()
+	device.flush()
+	device.flushing.Unlock()
+	device.sendQueueState()
+
+	for _, id := range polled {
+		device.Node().GetValueWithId(id).Refresh()
+	}
+}
+
+//
+// Called when the node has been heard from, and so is awake, without an
+// explicit wake up notification: the queued commands are run, but the polled
+// values are not refreshed, since their reports would be heard in turn.
+//
+func (device *Device) Heard() {
+	device.flushing.Lock()
+	defer device.flushing.Unlock()
+	device.applyParameters()
+	device.flush()
+}
+
+//
+// Run the queued commands in order. Called with the flushing lock held, so
+// that the commands of concurrent wake ups are not interleaved, and are not
+// run while a desired value is being checked and written.
+//
+func (device *Device) flush() {
+	device.mutex.Lock()
+	queue := device.queue
+	device.queue = nil
+	device.mutex.Unlock()
+
+	if len(queue) == 0 {
+		return
+	}
+
+	for _, queued := range queue {
+		if err := queued.run(); err != nil {
+			device.Driver.ZWave().Logger().Warningf("queued command %s for %v failed: %s", queued.key, device.Node(), err)
+		}
+	}
+	device.sendQueueState()
+}
+
+func (device *Device) QueueState() *QueueState {
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+
+	state := &QueueState{
+		Depth:      len(device.queue),
+		Pending:    make([]string, len(device.queue)),
+		LastWakeUp: device.lastWakeUp,
+	}
+	for i, queued := range device.queue {
+		state.Pending[i] = queued.key
+	}
+	return state
+}
+
+func (device *Device) sendQueueState() {
+	if device.SendEvent != nil {
+		device.SendEvent("queue", device.QueueState())
+	}
+}
@@ -0,0 +1,154 @@
+package spi_test
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/ninjasphere/go-openzwave"
+	"github.com/ninjasphere/go-openzwave/CC"
+
+	"github.com/ninjasphere/driver-go-zwave/fake"
+	"github.com/ninjasphere/driver-go-zwave/spi"
+)
+
+var (
+	wake_up_interval = openzwave.ValueID{CC.WAKE_UP, 1, 0}
+	parameter_3      = openzwave.ValueID{CC.CONFIGURATION, 1, 3}
+)
+
+//
+// sleeper is a minimal adapter for a battery device that sleeps.
+//
+type sleeper struct {
+	spi.Device
+}
+
+func newSleeper(driver spi.Driver, node openzwave.Node) openzwave.Device {
+	device := &sleeper{}
+	device.Init(driver, node)
+	return device
+}
+
+func (device *sleeper) NodeAdded()                         {}
+func (device *sleeper) NodeChanged()                       {}
+func (device *sleeper) NodeRemoved()                       {}
+func (device *sleeper) ValueChanged(value openzwave.Value) {}
+
+func joinSleeper(t *testing.T) (*fake.Driver, *fake.Node, *sleeper) {
+	driver := fake.NewDriver(newSleeper)
+	node := driver.API.NewNode(7, openzwave.ProductId{"0086", "0005"}, openzwave.ProductDescription{"Aeon Labs", "Multisensor", "Multisensor"})
+	node.AddValue(wake_up_interval, 3600.0)
+	node.AddValue(parameter_3, "240")
+	device := driver.API.Join(node).(*sleeper)
+	if !device.Sleeps() {
+		t.Fatal("expected the device to sleep")
+	}
+	return driver, node, device
+}
+
+//
+// Answer a command that records its key when it is run.
+//
+func recorder(mutex *sync.Mutex, run *[]string, key string) func() error {
+	return func() error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		*run = append(*run, key)
+		return nil
+	}
+}
+
+func TestCommandsAreQueuedWhileAsleep(t *testing.T) {
+	_, _, device := joinSleeper(t)
+	var mutex sync.Mutex
+	run := []string{}
+
+	device.Queue("a", recorder(&mutex, &run, "first a"))
+	device.Queue("b", recorder(&mutex, &run, "b"))
+	device.Queue("a", recorder(&mutex, &run, "second a"))
+
+	if len(run) != 0 {
+		t.Errorf("expected no command to run while the node is asleep, got %v", run)
+	}
+	if state := device.QueueState(); state.Depth != 2 || !reflect.DeepEqual(state.Pending, []string{"a", "b"}) {
+		t.Errorf("expected commands a and b to be pending, got %+v", state)
+	}
+}
+
+func TestQueuedCommandsRunInOrderOnWakeUp(t *testing.T) {
+	_, _, device := joinSleeper(t)
+	var mutex sync.Mutex
+	run := []string{}
+
+	device.Queue("a", recorder(&mutex, &run, "first a"))
+	device.Queue("b", recorder(&mutex, &run, "b"))
+	device.Queue("a", recorder(&mutex, &run, "second a"))
+	device.Queue("c", recorder(&mutex, &run, "c"))
+
+	device.WakeUp()
+
+	if expected := []string{"second a", "b", "c"}; !reflect.DeepEqual(run, expected) {
+		t.Errorf("expected the commands to run in the order queued, with the latest a, got %v", run)
+	}
+	if state := device.QueueState(); state.Depth != 0 || state.LastWakeUp == nil {
+		t.Errorf("expected an empty queue and the time of the wake up, got %+v", state)
+	}
+}
+
+//
+// The driver calls Heard concurrently with the wake up notification, so
+// each queued command must still run exactly once, in order.
+//
+func TestQueuedCommandsRunOnceWhenHeardConcurrently(t *testing.T) {
+	_, _, device := joinSleeper(t)
+	var mutex sync.Mutex
+	run := []string{}
+
+	expected := []string{}
+	for i := 0; i < 20; i++ {
+		key := strconv.Itoa(i)
+		device.Queue(key, recorder(&mutex, &run, key))
+		expected = append(expected, key)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			device.Heard()
+		}()
+	}
+	device.WakeUp()
+	wg.Wait()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !reflect.DeepEqual(run, expected) {
+		t.Errorf("expected each command to run once, in order, got %v", run)
+	}
+}
+
+func TestParameterIsWrittenOnceWhenHeardConcurrently(t *testing.T) {
+	driver, node, device := joinSleeper(t)
+	value := node.GetValueWithId(parameter_3).(*fake.Value).SetBehavior(fake.Ignore)
+	driver.UpdateDeviceConfig(device.Info.NaturalID, func(config *spi.DeviceConfig) {
+		config.Parameters["3"] = 60
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			device.Heard()
+		}()
+	}
+	wg.Wait()
+
+	if sets := value.Sets(); !reflect.DeepEqual(sets, []interface{}{"60"}) {
+		t.Errorf("expected the parameter to be written once, got %v", sets)
+	}
+}
@@ -0,0 +1,141 @@
+package spi
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ninjasphere/driver-go-zwave/utils"
+)
+
+//
+// DefaultPolicy is the reporting policy of channels whose adapter has no
+// better default: unchanged values are reported at most every 30 seconds,
+// and the last value is reported again every 15 minutes so that clients
+// that connect late see it.
+//
+var DefaultPolicy = utils.Policy{
+	RepeatAfter: 30 * time.Second,
+	Heartbeat:   15 * time.Minute,
+}
+
+//
+// ReportingPolicy is the configurable form of a utils.Policy. Intervals are
+// in seconds. A field that is not set leaves the value of the less specific
+// policy in place: a device's policy for a channel overrides the driver's
+// policy for the channel, which overrides the adapter's default.
+//
+type ReportingPolicy struct {
+	MinInterval *float64 `json:"minInterval,omitempty"` // hold back changes for minInterval seconds, per mode
+	Mode        *string  `json:"mode,omitempty"`        // "throttle" (the default) or "debounce"
+	Burst       *int     `json:"burst,omitempty"`       // in throttle mode, the number of changes reported immediately per minInterval
+	RepeatAfter *float64 `json:"repeatAfter,omitempty"` // report an unchanged value again if received repeatAfter seconds after it was last reported
+	Threshold   *float64 `json:"threshold,omitempty"`   // ignore changes smaller than threshold
+	Heartbeat   *float64 `json:"heartbeat,omitempty"`   // report the last value again if nothing has been reported for heartbeat seconds
+}
+
+func (policy *ReportingPolicy) Validate() error {
+	if policy == nil {
+		return nil
+	}
+	for name, value := range map[string]*float64{
+		"minInterval": policy.MinInterval,
+		"repeatAfter": policy.RepeatAfter,
+		"threshold":   policy.Threshold,
+		"heartbeat":   policy.Heartbeat,
+	} {
+		if value != nil && *value < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	if policy.Mode != nil && *policy.Mode != utils.ModeThrottle && *policy.Mode != utils.ModeDebounce {
+		return fmt.Errorf("mode must be %s or %s", utils.ModeThrottle, utils.ModeDebounce)
+	}
+	if policy.Burst != nil && *policy.Burst < 1 {
+		return fmt.Errorf("burst must be at least 1")
+	}
+	return nil
+}
+
+//
+// Override the fields of the utils.Policy that are set in this policy.
+//
+func (policy *ReportingPolicy) apply(result utils.Policy) utils.Policy {
+	if policy == nil {
+		return result
+	}
+	if policy.MinInterval != nil {
+		result.MinInterval = seconds(*policy.MinInterval)
+	}
+	if policy.Mode != nil {
+		result.Mode = *policy.Mode
+	}
+	if policy.Burst != nil {
+		result.Burst = *policy.Burst
+	}
+	if policy.RepeatAfter != nil {
+		result.RepeatAfter = seconds(*policy.RepeatAfter)
+	}
+	if policy.Threshold != nil {
+		result.Threshold = *policy.Threshold
+	}
+	if policy.Heartbeat != nil {
+		result.Heartbeat = seconds(*policy.Heartbeat)
+	}
+	return result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+//
+// Answer the reporting policy of the specified channel of the device: the
+// adapter's default, overridden by the driver's and then the device's
+// configuration for the channel.
+//
+func (device *Device) Policy(channel string, defaults utils.Policy) utils.Policy {
+	policy := device.Driver.ReportingPolicy(channel).apply(defaults)
+	return device.Config().Reporting[channel].apply(policy)
+}
+
+//
+// Answer an emitter that reports the values of the specified channel with
+// the channel's reporting policy. Nothing is reported while the node is
+// absent from the network.
+//
+func (device *Device) Reporter(channel string, defaults utils.Policy, emitter func(next utils.Equatable)) utils.Emitter {
+	reporter := device.reporter(channel, defaults, emitter)
+
+	device.mutex.Lock()
+	defer device.mutex.Unlock()
+	device.reporters = append(device.reporters, reporter)
+
+	return reporter
+}
+
+//
+// Answer a reporter that, unlike those answered by Reporter, is not
+// republished. Suits event-like channels, such as motion, whose last value
+// would be taken for a new event.
+//
+func (device *Device) reporter(channel string, defaults utils.Policy, emitter func(next utils.Equatable)) utils.Repeater {
+	return utils.ReportWithClock(func(next utils.Equatable) {
+		if device.CheckPresent() == nil {
+			emitter(next)
+		}
+	}, device.Policy(channel, defaults), device.Driver.Clock())
+}
+
+//
+// Report the last value reported by each of the device's reporters again,
+// typically because a client has (re)connected and has missed them.
+//
+func (device *Device) Republish() {
+	device.mutex.Lock()
+	reporters := append([]utils.Repeater{}, device.reporters...)
+	device.mutex.Unlock()
+
+	for _, reporter := range reporters {
+		reporter.Repeat()
+	}
+}
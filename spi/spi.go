@@ -4,10 +4,27 @@ package spi
 import (
 	"github.com/ninjasphere/go-ninja/api"
 	"github.com/ninjasphere/go-openzwave"
+
+	"github.com/ninjasphere/driver-go-zwave/utils"
 )
 
 type Driver interface {
 	ZWave() openzwave.API
 	Ninja() ninja.Driver
-	Connection() *ninja.Connection
+	Connection() Connection
+	Names() *Names
+	Batteries() *Batteries
+	DeviceConfig(naturalID string) *DeviceConfig
+	UpdateDeviceConfig(naturalID string, update func(config *DeviceConfig)) error // atomically updates a copy of the configuration of a device, then replaces and persists it
+	ReportingPolicy(channel string) *ReportingPolicy                              // the driver-wide policy for channels with the specified id, or nil
+	Clock() utils.Clock                                                           // the clock used by reporters and device timers
+}
+
+//
+// Connection is the part of *ninja.Connection used by the device adapters,
+// so that adapters can be exercised without a live connection.
+//
+type Connection interface {
+	ExportDevice(device ninja.Device) error
+	ExportChannel(device ninja.Device, channel ninja.Channel, id string) error
 }
@@ -0,0 +1,191 @@
+// Records OpenZWave notifications as JSON lines, so that field problems can be replayed offline with the replay package
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ninjasphere/go-openzwave"
+	"github.com/ninjasphere/go-openzwave/NT"
+
+	"github.com/ninjasphere/driver-go-zwave/spi"
+)
+
+//
+// Record is one line of a trace: a notification, together with the node it
+// concerns and the value it carries at the time it was received.
+//
+type Record struct {
+	Time  time.Time    `json:"time"`
+	Type  int          `json:"type"`
+	Name  string       `json:"name,omitempty"`
+	Code  *int         `json:"code,omitempty"` // the notification code of NOTIFICATION notifications, if known
+	Node  *NodeRecord  `json:"node,omitempty"`
+	Value *ValueRecord `json:"value,omitempty"`
+}
+
+type NodeRecord struct {
+	HomeId           uint32 `json:"homeId"`
+	Id               uint8  `json:"id"`
+	ManufacturerId   string `json:"manufacturerId"`
+	ProductId        string `json:"productId"`
+	ManufacturerName string `json:"manufacturerName,omitempty"`
+	ProductName      string `json:"productName,omitempty"`
+	ProductType      string `json:"productType,omitempty"`
+}
+
+//
+// ValueRecord is a value id and the value it held. Type is one of "bool",
+// "uint8", "float" or "string", or empty if the value could not be read.
+//
+type ValueRecord struct {
+	CommandClassId uint8       `json:"commandClassId"`
+	Instance       uint8       `json:"instance"`
+	Index          uint8       `json:"index"`
+	Type           string      `json:"type,omitempty"`
+	Value          interface{} `json:"value,omitempty"`
+}
+
+func newNodeRecord(node openzwave.Node) *NodeRecord {
+	productId := node.GetProductId()
+	description := node.GetProductDescription()
+	return &NodeRecord{
+		HomeId:           node.GetHomeId(),
+		Id:               node.GetId(),
+		ManufacturerId:   productId.ManufacturerId,
+		ProductId:        productId.ProductId,
+		ManufacturerName: description.ManufacturerName,
+		ProductName:      description.ProductName,
+		ProductType:      description.ProductType,
+	}
+}
+
+func newValueRecord(value openzwave.Value) *ValueRecord {
+	id := value.Id()
+	record := &ValueRecord{
+		CommandClassId: id.CommandClassId,
+		Instance:       id.Instance,
+		Index:          id.Index,
+	}
+	if valB, ok := value.GetBool(); ok {
+		record.Type, record.Value = "bool", valB
+	} else if valU, ok := value.GetUint8(); ok {
+		record.Type, record.Value = "uint8", valU
+	} else if valF, ok := value.GetFloat(); ok {
+		record.Type, record.Value = "float", valF
+	} else if valS, ok := value.GetString(); ok {
+		record.Type, record.Value = "string", valS
+	}
+	return record
+}
+
+func (record *ValueRecord) Id() openzwave.ValueID {
+	return openzwave.ValueID{record.CommandClassId, record.Instance, record.Index}
+}
+
+//
+// Answer the recorded value with the Go type that the getter of the
+// recorded type answers, or nil if no value was recorded.
+//
+func (record *ValueRecord) Current() interface{} {
+	switch value := record.Value.(type) {
+	case bool:
+		if record.Type == "bool" {
+			return value
+		}
+	case float64:
+		switch record.Type {
+		case "uint8":
+			return uint8(value)
+		case "float":
+			return value
+		}
+	case string:
+		if record.Type == "string" {
+			return value
+		}
+	}
+	return nil
+}
+
+//
+// Recorder appends a record for each notification it is given to a trace
+// file. It is safe for use by concurrent callbacks.
+//
+type Recorder struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+//
+// Create a recorder that appends to the trace file at path, creating the file
+// if necessary.
+//
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func (recorder *Recorder) Record(nt openzwave.Notification) error {
+	notificationType := nt.GetNotificationType()
+	record := &Record{
+		Time: time.Now(),
+		Type: notificationType.Code,
+		Name: notificationType.Name,
+	}
+	if code, ok := spi.NotificationCode(nt); ok && notificationType.Code == NT.NOTIFICATION {
+		record.Code = &code
+	}
+	if node := nt.GetNode(); node != nil {
+		record.Node = newNodeRecord(node)
+	}
+	if value := nt.GetValue(); value != nil {
+		record.Value = newValueRecord(value)
+	}
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return recorder.encoder.Encode(record)
+}
+
+func (recorder *Recorder) Close() error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return recorder.file.Close()
+}
+
+//
+// Read the records of the trace file at path, in order.
+//
+func Load(path string) ([]*Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records := []*Record{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record := &Record{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
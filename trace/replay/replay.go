@@ -0,0 +1,98 @@
+// Replays traces recorded by the trace package through a fake API, so that field problems can be reproduced offline
+package replay
+
+import (
+	"time"
+
+	"github.com/ninjasphere/go-openzwave"
+	"github.com/ninjasphere/go-openzwave/NT"
+
+	"github.com/ninjasphere/driver-go-zwave/fake"
+	"github.com/ninjasphere/driver-go-zwave/trace"
+)
+
+//
+// Replay the trace at path through the fake API, which passes the nodes to
+// the device factory and the notifications to the notification callback the
+// API was built with.
+//
+// Every node of the trace is created with every value the trace mentions, set
+// to the first recorded value, before the first notification is replayed, so
+// device factories see the values the real node had when it was added. A node
+// is added to the network on its first NODE_ADDED notification and removed on
+// NODE_REMOVED. Other notifications update the value they carry before they
+// are dispatched.
+//
+// If realtime is true, the gaps between the recorded notifications are
+// preserved, otherwise the trace is replayed as quickly as possible.
+//
+func Run(path string, api *fake.API, realtime bool) error {
+	records, err := trace.Load(path)
+	if err != nil {
+		return err
+	}
+
+	nodes := make(map[uint8]*fake.Node)
+	for _, record := range records {
+		if record.Node == nil {
+			continue
+		}
+		node, ok := nodes[record.Node.Id]
+		if !ok {
+			api.SetHomeId(record.Node.HomeId)
+			node = api.NewNode(record.Node.Id,
+				openzwave.ProductId{record.Node.ManufacturerId, record.Node.ProductId},
+				openzwave.ProductDescription{record.Node.ManufacturerName, record.Node.ProductName, record.Node.ProductType})
+			nodes[record.Node.Id] = node
+		}
+		if record.Value == nil || record.Value.Current() == nil {
+			continue
+		}
+		if !node.HasValue(record.Value.Id()) {
+			node.AddValue(record.Value.Id(), record.Value.Current())
+		}
+	}
+
+	added := make(map[uint8]bool)
+	var last time.Time
+	for _, record := range records {
+		if realtime && !last.IsZero() && record.Time.After(last) {
+			time.Sleep(record.Time.Sub(last))
+		}
+		last = record.Time
+
+		var node *fake.Node
+		if record.Node != nil {
+			node = nodes[record.Node.Id]
+		}
+
+		switch record.Type {
+		case NT.NODE_ADDED:
+			if node != nil && !added[record.Node.Id] {
+				added[record.Node.Id] = true
+				api.Join(node)
+			}
+		case NT.NODE_REMOVED:
+			if node != nil && added[record.Node.Id] {
+				delete(added, record.Node.Id)
+				api.Leave(record.Node.Id)
+			}
+		case NT.NOTIFICATION:
+			if record.Code != nil {
+				api.NotifyCode(*record.Code, node)
+				break
+			}
+			api.Notify(record.Type, node, nil)
+		default:
+			var value *fake.Value
+			if node != nil && record.Value != nil && node.HasValue(record.Value.Id()) {
+				value = node.GetValueWithId(record.Value.Id()).(*fake.Value)
+				if current := record.Value.Current(); current != nil {
+					value.Update(current)
+				}
+			}
+			api.Notify(record.Type, node, value)
+		}
+	}
+	return nil
+}
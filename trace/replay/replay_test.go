@@ -0,0 +1,129 @@
+package replay
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/ninjasphere/go-openzwave"
+	"github.com/ninjasphere/go-openzwave/CC"
+	"github.com/ninjasphere/go-openzwave/NT"
+
+	"github.com/ninjasphere/driver-go-zwave/fake"
+	"github.com/ninjasphere/driver-go-zwave/spi"
+	"github.com/ninjasphere/driver-go-zwave/trace"
+)
+
+var (
+	temperature = openzwave.ValueID{CC.SENSOR_MULTILEVEL, 1, 1}
+	product     = openzwave.ProductId{"0086", "0005"}
+	description = openzwave.ProductDescription{"Aeon Labs", "Multisensor", "Multisensor"}
+)
+
+//
+// device records the values it is told about, and the value of the
+// temperature when it was built.
+//
+type device struct {
+	sync.Mutex
+	node    openzwave.Node
+	initial float64
+	values  []float64
+}
+
+func (device *device) NodeAdded()   {}
+func (device *device) NodeChanged() {}
+func (device *device) NodeRemoved() {}
+
+func (device *device) ValueChanged(v openzwave.Value) {
+	device.Lock()
+	defer device.Unlock()
+	if reading, ok := v.GetFloat(); ok {
+		device.values = append(device.values, reading)
+	}
+}
+
+func TestRecordLoadReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "trace.json")
+
+	recorder, err := trace.NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory := func(api openzwave.API, node openzwave.Node) openzwave.Device {
+		return &device{node: node}
+	}
+	recorded := fake.NewAPI(factory, func(api openzwave.API, nt openzwave.Notification) {
+		if err := recorder.Record(nt); err != nil {
+			t.Fatal(err)
+		}
+	})
+	node := recorded.NewNode(5, product, description)
+	reading := node.AddValue(temperature, 21.5)
+	recorded.Join(node)
+	reading.Emit(21.5)
+	reading.Emit(23.0)
+	recorded.NotifyCode(spi.CODE_AWAKE, node)
+	recorded.Leave(5)
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := trace.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := make([]int, len(records))
+	for i, record := range records {
+		types[i] = record.Type
+	}
+	expected := []int{NT.NODE_ADDED, NT.VALUE_CHANGED, NT.VALUE_CHANGED, NT.NOTIFICATION, NT.NODE_REMOVED}
+	if !reflect.DeepEqual(types, expected) {
+		t.Fatalf("expected records of types %v, got %v", expected, types)
+	}
+
+	var replayed *device
+	codes := []int{}
+	api := fake.NewAPI(func(api openzwave.API, node openzwave.Node) openzwave.Device {
+		replayed = &device{node: node}
+		replayed.initial, _ = node.GetValueWithId(temperature).GetFloat()
+		return replayed
+	}, func(api openzwave.API, nt openzwave.Notification) {
+		if code, ok := spi.NotificationCode(nt); ok && nt.GetNotificationType().Code == NT.NOTIFICATION {
+			codes = append(codes, code)
+		}
+	})
+	if err := Run(path, api, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if replayed == nil {
+		t.Fatal("expected the node to be added by the replay")
+	}
+	if id := replayed.node.GetId(); id != 5 {
+		t.Errorf("expected node 5, got %d", id)
+	}
+	if productId := *replayed.node.GetProductId(); productId != product {
+		t.Errorf("expected product %v, got %v", product, productId)
+	}
+	if replayed.initial != 21.5 {
+		t.Errorf("expected the node to be built with the first recorded value, got %v", replayed.initial)
+	}
+	if !reflect.DeepEqual(replayed.values, []float64{21.5, 23.0}) {
+		t.Errorf("expected the recorded values to be replayed, got %v", replayed.values)
+	}
+	if !reflect.DeepEqual(codes, []int{spi.CODE_AWAKE}) {
+		t.Errorf("expected the awake notification to be replayed, got %v", codes)
+	}
+	if api.Device(5) != nil {
+		t.Error("expected the node to be removed by the replay")
+	}
+}
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"time"
+)
+
+//
+// A Clock tells emitters the time and runs their deferred emissions. Emitters
+// use the system clock unless they are given another, typically a fake clock
+// under test.
+//
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+//
+// A Timer is a deferred call that can be cancelled. Stop answers false if the
+// call has already been made or cancelled.
+//
+type Timer interface {
+	Stop() bool
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+var SystemClock Clock = systemClock{}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+type debouncedEmitter struct {
+	mutex    sync.Mutex // guards pending, timer and generation
+	emitting sync.Mutex // serializes calls to emitter
+	clock    Clock
+
+	quiet      time.Duration
+	pending    Equatable
+	timer      Timer
+	generation int // incremented whenever the pending value is replaced or discarded
+	emitter    func(next Equatable)
+}
+
+//
+// Creates a new, debounced emitter, such that the wrapped emitter is called
+// with the last value of a burst once no value has been emitted for the quiet
+// period. Suits values that change rapidly while a device settles.
+//
+func Debounce(emitter func(next Equatable), quiet time.Duration) Emitter {
+	return DebounceWithClock(emitter, quiet, SystemClock)
+}
+
+//
+// Creates a new, debounced emitter that uses the specified clock.
+//
+func DebounceWithClock(emitter func(next Equatable), quiet time.Duration, clock Clock) Emitter {
+	return &debouncedEmitter{
+		clock:   clock,
+		quiet:   quiet,
+		emitter: emitter,
+	}
+}
+
+func (d *debouncedEmitter) Emit(next Equatable) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.pending = next
+	d.generation++
+	generation := d.generation
+	d.timer = d.clock.AfterFunc(d.quiet, func() {
+		d.flush(generation)
+	})
+}
+
+func (d *debouncedEmitter) flush(generation int) {
+	d.emitting.Lock()
+	defer d.emitting.Unlock()
+
+	d.mutex.Lock()
+	if generation != d.generation || d.pending == nil {
+		// superseded by a later value, or discarded by Reset
+		d.mutex.Unlock()
+		return
+	}
+	next := d.pending
+	d.pending = nil
+	d.timer = nil
+	d.mutex.Unlock()
+
+	d.emitter(next)
+}
+
+//
+// Discard the pending value, if any.
+//
+func (d *debouncedEmitter) Reset() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.pending = nil
+	d.generation++
+}
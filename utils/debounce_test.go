@@ -0,0 +1,38 @@
+package utils_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ninjasphere/driver-go-zwave/fake"
+	"github.com/ninjasphere/driver-go-zwave/utils"
+)
+
+func TestDebounceEmitsLastValueOnceQuiet(t *testing.T) {
+	clock := fake.NewClock()
+	reported := []float64{}
+	emitter := utils.DebounceWithClock(collect(&reported), 5*time.Second, clock)
+
+	emitter.Emit(utils.WrapFloat(1))
+	clock.Advance(4 * time.Second)
+	emitter.Emit(utils.WrapFloat(2))
+	clock.Advance(4 * time.Second)
+	expectReported(t, reported)
+
+	clock.Advance(time.Second)
+	expectReported(t, reported, 2)
+	if clock.Pending() != 0 {
+		t.Errorf("expected no pending timers, got %d", clock.Pending())
+	}
+}
+
+func TestDebounceResetDiscardsPendingValue(t *testing.T) {
+	clock := fake.NewClock()
+	reported := []float64{}
+	emitter := utils.DebounceWithClock(collect(&reported), 5*time.Second, clock)
+
+	emitter.Emit(utils.WrapFloat(1))
+	emitter.Reset()
+	clock.Advance(10 * time.Second)
+	expectReported(t, reported)
+}
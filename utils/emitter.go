@@ -1,18 +1,38 @@
 package utils
 
 import (
+	"sync"
 	"time"
 )
 
+//
+// An Emitter passes values on to a wrapped emitter function, possibly
+// suppressing some of them. Emit and Reset may be called concurrently, but
+// the wrapped emitter function must not call Emit on the same emitter.
+//
 type Emitter interface {
 	Emit(next Equatable)
 	Reset()
 }
 
+//
+// A Repeater is an Emitter that can emit the last value it emitted again,
+// on demand.
+//
+type Repeater interface {
+	Emitter
+	Repeat()
+}
+
 type filteredEmitter struct {
-	last     Equatable
-	lastTime time.Time
-	filter   func(Equatable)
+	mutex    sync.Mutex // guards last and lastTime
+	emitting sync.Mutex // serializes calls to emitter, so values are emitted in the order they were accepted
+	clock    Clock
+
+	last      Equatable
+	lastTime  time.Time
+	minPeriod time.Duration
+	emitter   func(next Equatable)
 }
 
 //
@@ -20,33 +40,53 @@ type filteredEmitter struct {
 // most once per minPeriod if the emitted value does not change.
 //
 func Filter(emitter func(next Equatable), minPeriod time.Duration) Emitter {
-	var f *filteredEmitter
-
-	f = &filteredEmitter{
-		last:     nil,
-		lastTime: time.Now(),
-		filter: func(next Equatable) {
-			now := time.Now()
-			if f.last != nil &&
-				f.last.Equals(next) &&
-				now.Sub(f.lastTime) < minPeriod {
-				return
-			} else {
-				f.last = next
-				f.lastTime = now
-				emitter(next)
-			}
-		},
+	return FilterWithClock(emitter, minPeriod, SystemClock)
+}
+
+//
+// Creates a new, filtered emitter that tells the time with the specified clock.
+//
+func FilterWithClock(emitter func(next Equatable), minPeriod time.Duration, clock Clock) Emitter {
+	return &filteredEmitter{
+		clock:     clock,
+		last:      nil,
+		lastTime:  clock.Now(),
+		minPeriod: minPeriod,
+		emitter:   emitter,
 	}
+}
 
-	return f
+func (f *filteredEmitter) Emit(next Equatable) {
+	f.emitting.Lock()
+	defer f.emitting.Unlock()
 
+	if !f.accept(next) {
+		return
+	}
+	f.emitter(next)
 }
 
-func (f *filteredEmitter) Emit(next Equatable) {
-	f.filter(next)
+func (f *filteredEmitter) accept(next Equatable) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	now := f.clock.Now()
+	if f.last != nil &&
+		f.last.Equals(next) &&
+		now.Sub(f.lastTime) < f.minPeriod {
+		return false
+	}
+	f.last = next
+	f.lastTime = now
+	return true
 }
 
+//
+// Forget the last value, so that the next value is emitted whether or not
+// it has changed. Reset may be called by the wrapped emitter.
+//
 func (f *filteredEmitter) Reset() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
 	f.last = nil
 }
@@ -0,0 +1,68 @@
+package utils_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ninjasphere/driver-go-zwave/fake"
+	"github.com/ninjasphere/driver-go-zwave/utils"
+)
+
+func TestFilterSuppressesUnchangedValueWithinPeriod(t *testing.T) {
+	clock := fake.NewClock()
+	reported := []float64{}
+	emitter := utils.FilterWithClock(collect(&reported), 30*time.Second, clock)
+
+	emitter.Emit(utils.WrapFloat(1))
+	clock.Advance(10 * time.Second)
+	emitter.Emit(utils.WrapFloat(1))
+	emitter.Emit(utils.WrapFloat(2))
+	expectReported(t, reported, 1, 2)
+
+	clock.Advance(30 * time.Second)
+	emitter.Emit(utils.WrapFloat(2))
+	expectReported(t, reported, 1, 2, 2)
+}
+
+func TestFilterResetEmitsUnchangedValue(t *testing.T) {
+	clock := fake.NewClock()
+	reported := []float64{}
+	emitter := utils.FilterWithClock(collect(&reported), 30*time.Second, clock)
+
+	emitter.Emit(utils.WrapFloat(1))
+	emitter.Reset()
+	emitter.Emit(utils.WrapFloat(1))
+	expectReported(t, reported, 1, 1)
+}
+
+func TestFilterIsSafeForConcurrentUse(t *testing.T) {
+	var mutex sync.Mutex
+	emitted := 0
+	emitter := utils.Filter(func(next utils.Equatable) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		emitted++
+	}, time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				emitter.Emit(utils.WrapFloat(42))
+				if j%10 == 0 {
+					emitter.Reset()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if emitted < 1 || emitted > 8*10+1 {
+		t.Errorf("expected the unchanged value to be emitted once per reset at most, got %d", emitted)
+	}
+}
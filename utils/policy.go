@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+//
+// The modes in which a Policy holds back changes within MinInterval.
+//
+const (
+	ModeThrottle = "throttle" // report the first Burst changes of each MinInterval immediately, and the latest of the rest when it elapses
+	ModeDebounce = "debounce" // report the latest change once MinInterval has passed without another
+)
+
+//
+// A Scalar is an Equatable value that can be measured, so that the size of a
+// change can be compared with a Policy's Threshold.
+//
+type Scalar interface {
+	Equatable
+	Float() float64
+}
+
+//
+// A Policy says when the values of a channel are reported. Changes are
+// reported immediately, unless MinInterval and Mode say otherwise. Unchanged
+// values are not reported again, unless RepeatAfter says otherwise.
+// Independently of the values received, the last value reported is reported
+// again whenever Heartbeat elapses without a report.
+//
+// RepeatAfter is not a timer: it only lets an unchanged value through when
+// the device sends it again, so a device that stops sending is not repeated.
+// Use Heartbeat for periodic reports.
+//
+type Policy struct {
+	MinInterval time.Duration // changes are held back per Mode for MinInterval; the latest change is reported when it elapses
+	Mode        string        // ModeThrottle, the default, or ModeDebounce
+	Burst       int           // in ModeThrottle, the number of changes reported immediately in each MinInterval; at least 1
+	RepeatAfter time.Duration // an unchanged value is reported again if it is received at least RepeatAfter after it was last reported
+	Threshold   float64       // a Scalar value is only a change if it differs from the last value reported by at least Threshold
+	Heartbeat   time.Duration // the last value is reported again if nothing has been reported for Heartbeat
+}
+
+type policyEmitter struct {
+	mutex    sync.Mutex // guards last, lastTime, held and heartbeat
+	emitting sync.Mutex // serializes calls to emitter
+	clock    Clock
+	policy   Policy
+
+	gate      Emitter   // holds back changes within MinInterval, nil if there is no MinInterval
+	last      Equatable // the last value reported, nil if none
+	lastTime  time.Time
+	held      bool // true if the gate may be holding back a change
+	heartbeat Timer
+	emitter   func(next Equatable)
+}
+
+//
+// Creates a new emitter that applies the specified reporting policy to the
+// values it is given. Changes within MinInterval are held back by a Throttle
+// or a Debounce, according to the policy's Mode.
+//
+func Report(emitter func(next Equatable), policy Policy) Repeater {
+	return ReportWithClock(emitter, policy, SystemClock)
+}
+
+//
+// Creates a new reporting emitter that uses the specified clock.
+//
+func ReportWithClock(emitter func(next Equatable), policy Policy, clock Clock) Repeater {
+	p := &policyEmitter{
+		clock:   clock,
+		policy:  policy,
+		emitter: emitter,
+	}
+	if policy.MinInterval > 0 {
+		switch policy.Mode {
+		case ModeDebounce:
+			p.gate = DebounceWithClock(p.deliver, policy.MinInterval, clock)
+		default:
+			p.gate = ThrottleWithClock(p.deliver, policy.Burst, policy.MinInterval, clock)
+		}
+	}
+	return p
+}
+
+func (p *policyEmitter) Emit(next Equatable) {
+	p.emitting.Lock()
+	report, hold := p.accept(next)
+	if report {
+		defer p.emitting.Unlock()
+		p.emitter(next)
+		return
+	}
+	p.emitting.Unlock()
+
+	if hold {
+		// the gate calls deliver, which serializes with the emitter itself
+		p.gate.Emit(next)
+	}
+}
+
+//
+// Answer whether the value is to be reported now, or passed to the gate to
+// be held back.
+//
+func (p *policyEmitter) accept(next Equatable) (report bool, hold bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := p.clock.Now()
+	elapsed := now.Sub(p.lastTime)
+
+	if p.last != nil && !p.changed(next) {
+		if p.held {
+			// the value has returned to the value last reported: let it
+			// supersede the held back change, then discard it in deliver
+			return false, true
+		}
+		if p.policy.RepeatAfter <= 0 || elapsed < p.policy.RepeatAfter {
+			return false, false
+		}
+	} else if p.gate != nil {
+		p.held = true
+		return false, true
+	}
+
+	p.reported(next, now)
+	return true, false
+}
+
+//
+// Report a change let through by the gate, unless it is no longer a change.
+//
+func (p *policyEmitter) deliver(next Equatable) {
+	p.emitting.Lock()
+	defer p.emitting.Unlock()
+
+	p.mutex.Lock()
+	p.held = false
+	if p.last != nil && !p.changed(next) {
+		p.mutex.Unlock()
+		return
+	}
+	p.reported(next, p.clock.Now())
+	p.mutex.Unlock()
+
+	p.emitter(next)
+}
+
+//
+// Note that the value has been reported, and restart the heartbeat.
+//
+func (p *policyEmitter) reported(next Equatable, now time.Time) {
+	p.last = next
+	p.lastTime = now
+	if p.heartbeat != nil {
+		p.heartbeat.Stop()
+		p.heartbeat = nil
+	}
+	if p.policy.Heartbeat > 0 {
+		p.heartbeat = p.clock.AfterFunc(p.policy.Heartbeat, p.Repeat)
+	}
+}
+
+//
+// Answer true if the value is a change from the last value reported.
+//
+func (p *policyEmitter) changed(next Equatable) bool {
+	lastS, lastOk := p.last.(Scalar)
+	nextS, nextOk := next.(Scalar)
+	if lastOk && nextOk && p.policy.Threshold > 0 {
+		return math.Abs(nextS.Float()-lastS.Float()) >= p.policy.Threshold
+	}
+	return !p.last.Equals(next)
+}
+
+//
+// Report the last value reported again, if there is one.
+//
+func (p *policyEmitter) Repeat() {
+	p.emitting.Lock()
+	defer p.emitting.Unlock()
+
+	p.mutex.Lock()
+	last := p.last
+	if last == nil {
+		p.mutex.Unlock()
+		return
+	}
+	p.reported(last, p.clock.Now())
+	p.mutex.Unlock()
+
+	p.emitter(last)
+}
+
+//
+// Forget the last value reported and discard the held back change, if any,
+// so that the next value is reported as soon as the policy's Mode allows.
+//
+func (p *policyEmitter) Reset() {
+	p.mutex.Lock()
+	if p.heartbeat != nil {
+		p.heartbeat.Stop()
+		p.heartbeat = nil
+	}
+	p.last = nil
+	p.held = false
+	p.mutex.Unlock()
+
+	if p.gate != nil {
+		p.gate.Reset()
+	}
+}
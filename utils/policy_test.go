@@ -0,0 +1,169 @@
+package utils_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ninjasphere/driver-go-zwave/fake"
+	"github.com/ninjasphere/driver-go-zwave/utils"
+)
+
+//
+// Answer an emitter function that appends the floats it is given to the
+// reported slice.
+//
+func collect(reported *[]float64) func(next utils.Equatable) {
+	return func(next utils.Equatable) {
+		*reported = append(*reported, next.(*utils.WrappedFloat).Unwrap())
+	}
+}
+
+func expectReported(t *testing.T, reported []float64, expected ...float64) {
+	if len(expected) == 0 && len(reported) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(reported, expected) {
+		t.Errorf("expected %v to be reported, got %v", expected, reported)
+	}
+}
+
+func TestReportHoldsBackChangesWithinMinInterval(t *testing.T) {
+	clock := fake.NewClock()
+	reported := []float64{}
+	emitter := utils.ReportWithClock(collect(&reported), utils.Policy{MinInterval: 5 * time.Second}, clock)
+
+	emitter.Emit(utils.WrapFloat(1))
+	clock.Advance(time.Second)
+	emitter.Emit(utils.WrapFloat(2))
+	clock.Advance(time.Second)
+	emitter.Emit(utils.WrapFloat(3))
+	expectReported(t, reported, 1)
+
+	clock.Advance(3 * time.Second)
+	expectReported(t, reported, 1, 3)
+	if clock.Pending() != 0 {
+		t.Errorf("expected no pending timers, got %d", clock.Pending())
+	}
+}
+
+func TestReportDiscardsChangeThatReverts(t *testing.T) {
+	clock := fake.NewClock()
+	reported := []float64{}
+	emitter := utils.ReportWithClock(collect(&reported), utils.Policy{MinInterval: 5 * time.Second}, clock)
+
+	emitter.Emit(utils.WrapFloat(1))
+	clock.Advance(time.Second)
+	emitter.Emit(utils.WrapFloat(2))
+	emitter.Emit(utils.WrapFloat(1))
+	clock.Advance(10 * time.Second)
+
+	expectReported(t, reported, 1)
+}
+
+func TestReportRepeatsUnchangedValueReceivedAfterRepeatAfter(t *testing.T) {
+	clock := fake.NewClock()
+	reported := []float64{}
+	emitter := utils.ReportWithClock(collect(&reported), utils.Policy{RepeatAfter: 30 * time.Second}, clock)
+
+	emitter.Emit(utils.WrapFloat(1))
+	clock.Advance(10 * time.Second)
+	emitter.Emit(utils.WrapFloat(1))
+	expectReported(t, reported, 1)
+
+	clock.Advance(20 * time.Second)
+	emitter.Emit(utils.WrapFloat(1))
+	expectReported(t, reported, 1, 1)
+}
+
+func TestReportDoesNotRepeatUnchangedValueThatIsNotReceived(t *testing.T) {
+	clock := fake.NewClock()
+	reported := []float64{}
+	emitter := utils.ReportWithClock(collect(&reported), utils.Policy{RepeatAfter: 30 * time.Second}, clock)
+
+	emitter.Emit(utils.WrapFloat(1))
+	clock.Advance(time.Hour)
+	expectReported(t, reported, 1)
+}
+
+func TestReportIgnoresChangesWithinThreshold(t *testing.T) {
+	clock := fake.NewClock()
+	reported := []float64{}
+	emitter := utils.ReportWithClock(collect(&reported), utils.Policy{Threshold: 0.5}, clock)
+
+	emitter.Emit(utils.WrapFloat(20))
+	emitter.Emit(utils.WrapFloat(20.3))
+	emitter.Emit(utils.WrapFloat(19.6))
+	emitter.Emit(utils.WrapFloat(20.6))
+
+	expectReported(t, reported, 20, 20.6)
+}
+
+func TestReportHeartbeat(t *testing.T) {
+	clock := fake.NewClock()
+	reported := []float64{}
+	emitter := utils.ReportWithClock(collect(&reported), utils.Policy{Heartbeat: 15 * time.Minute}, clock)
+
+	emitter.Emit(utils.WrapFloat(1))
+	clock.Advance(10 * time.Minute)
+	emitter.Emit(utils.WrapFloat(2))
+	clock.Advance(10 * time.Minute)
+	expectReported(t, reported, 1, 2)
+
+	clock.Advance(5 * time.Minute)
+	expectReported(t, reported, 1, 2, 2)
+
+	emitter.Reset()
+	clock.Advance(time.Hour)
+	expectReported(t, reported, 1, 2, 2)
+}
+
+func TestReportRepeat(t *testing.T) {
+	clock := fake.NewClock()
+	reported := []float64{}
+	emitter := utils.ReportWithClock(collect(&reported), utils.Policy{}, clock)
+
+	emitter.Repeat()
+	expectReported(t, reported)
+
+	emitter.Emit(utils.WrapFloat(1))
+	emitter.Repeat()
+	expectReported(t, reported, 1, 1)
+}
+
+func TestReportDebounceMode(t *testing.T) {
+	clock := fake.NewClock()
+	reported := []float64{}
+	policy := utils.Policy{MinInterval: 5 * time.Second, Mode: utils.ModeDebounce}
+	emitter := utils.ReportWithClock(collect(&reported), policy, clock)
+
+	emitter.Emit(utils.WrapFloat(1))
+	clock.Advance(5 * time.Second)
+	expectReported(t, reported, 1)
+
+	emitter.Emit(utils.WrapFloat(2))
+	clock.Advance(3 * time.Second)
+	emitter.Emit(utils.WrapFloat(3))
+	clock.Advance(3 * time.Second)
+	expectReported(t, reported, 1)
+
+	clock.Advance(2 * time.Second)
+	expectReported(t, reported, 1, 3)
+}
+
+func TestReportThrottleBurst(t *testing.T) {
+	clock := fake.NewClock()
+	reported := []float64{}
+	policy := utils.Policy{MinInterval: 10 * time.Second, Burst: 2}
+	emitter := utils.ReportWithClock(collect(&reported), policy, clock)
+
+	emitter.Emit(utils.WrapFloat(1))
+	emitter.Emit(utils.WrapFloat(1))
+	emitter.Emit(utils.WrapFloat(2))
+	emitter.Emit(utils.WrapFloat(3))
+	emitter.Emit(utils.WrapFloat(4))
+	expectReported(t, reported, 1, 2)
+
+	clock.Advance(10 * time.Second)
+	expectReported(t, reported, 1, 2, 4)
+}
@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+type throttledEmitter struct {
+	mutex    sync.Mutex // guards recent, pending and timer
+	emitting sync.Mutex // serializes calls to emitter
+	clock    Clock
+
+	max     int
+	period  time.Duration
+	recent  []time.Time // the times of the emissions within the last period, oldest first
+	pending Equatable   // the latest value suppressed since the last emission, if any
+	timer   Timer
+	emitter func(next Equatable)
+}
+
+//
+// Creates a new, throttled emitter, such that the wrapped emitter is called at
+// most max times in any period. Values that arrive when the limit has been
+// reached are held back; the latest of them is emitted as soon as the limit
+// allows, so the final value of a burst is never lost.
+//
+func Throttle(emitter func(next Equatable), max int, period time.Duration) Emitter {
+	return ThrottleWithClock(emitter, max, period, SystemClock)
+}
+
+//
+// Creates a new, throttled emitter that uses the specified clock.
+//
+func ThrottleWithClock(emitter func(next Equatable), max int, period time.Duration, clock Clock) Emitter {
+	if max < 1 {
+		max = 1
+	}
+	return &throttledEmitter{
+		clock:   clock,
+		max:     max,
+		period:  period,
+		recent:  make([]time.Time, 0, max),
+		emitter: emitter,
+	}
+}
+
+func (t *throttledEmitter) Emit(next Equatable) {
+	t.emitting.Lock()
+	defer t.emitting.Unlock()
+
+	if !t.accept(next) {
+		return
+	}
+	t.emitter(next)
+}
+
+//
+// Answer true if the value can be emitted now, otherwise hold it back
+// until the limit allows.
+//
+func (t *throttledEmitter) accept(next Equatable) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.clock.Now()
+	t.expire(now)
+	if t.pending == nil && len(t.recent) < t.max {
+		t.recent = append(t.recent, now)
+		return true
+	}
+
+	t.pending = next
+	if t.timer == nil {
+		t.timer = t.clock.AfterFunc(t.recent[0].Add(t.period).Sub(now), t.flush)
+	}
+	return false
+}
+
+//
+// Forget the emissions that no longer count against the limit.
+//
+func (t *throttledEmitter) expire(now time.Time) {
+	for len(t.recent) > 0 && !now.Before(t.recent[0].Add(t.period)) {
+		t.recent = t.recent[1:]
+	}
+}
+
+func (t *throttledEmitter) flush() {
+	t.emitting.Lock()
+	defer t.emitting.Unlock()
+
+	t.mutex.Lock()
+	t.timer = nil
+	next := t.pending
+	if next == nil {
+		t.mutex.Unlock()
+		return
+	}
+	now := t.clock.Now()
+	t.expire(now)
+	if len(t.recent) >= t.max {
+		// not yet - wait for the oldest emission to expire
+		t.timer = t.clock.AfterFunc(t.recent[0].Add(t.period).Sub(now), t.flush)
+		t.mutex.Unlock()
+		return
+	}
+	t.pending = nil
+	t.recent = append(t.recent, now)
+	t.mutex.Unlock()
+
+	t.emitter(next)
+}
+
+//
+// Discard the held back value, if any, and forget previous emissions, so
+// that the next value is emitted immediately.
+//
+func (t *throttledEmitter) Reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	t.pending = nil
+	t.recent = t.recent[:0]
+}
@@ -0,0 +1,61 @@
+package utils_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ninjasphere/driver-go-zwave/fake"
+	"github.com/ninjasphere/driver-go-zwave/utils"
+)
+
+func TestThrottleFlushesLatestValueOfBurst(t *testing.T) {
+	clock := fake.NewClock()
+	reported := []float64{}
+	emitter := utils.ThrottleWithClock(collect(&reported), 2, 10*time.Second, clock)
+
+	emitter.Emit(utils.WrapFloat(1))
+	emitter.Emit(utils.WrapFloat(2))
+	emitter.Emit(utils.WrapFloat(3))
+	clock.Advance(time.Second)
+	emitter.Emit(utils.WrapFloat(4))
+	expectReported(t, reported, 1, 2)
+
+	clock.Advance(9 * time.Second)
+	expectReported(t, reported, 1, 2, 4)
+	if clock.Pending() != 0 {
+		t.Errorf("expected no pending timers, got %d", clock.Pending())
+	}
+}
+
+func TestThrottleFlushWaitsForTheLimit(t *testing.T) {
+	clock := fake.NewClock()
+	reported := []float64{}
+	emitter := utils.ThrottleWithClock(collect(&reported), 1, 10*time.Second, clock)
+
+	emitter.Emit(utils.WrapFloat(1))
+	emitter.Emit(utils.WrapFloat(2))
+	clock.Advance(10 * time.Second)
+	expectReported(t, reported, 1, 2)
+
+	// the flush counts against the limit, so the next value is held back too
+	emitter.Emit(utils.WrapFloat(3))
+	clock.Advance(5 * time.Second)
+	expectReported(t, reported, 1, 2)
+	clock.Advance(5 * time.Second)
+	expectReported(t, reported, 1, 2, 3)
+}
+
+func TestThrottleResetDiscardsHeldValue(t *testing.T) {
+	clock := fake.NewClock()
+	reported := []float64{}
+	emitter := utils.ThrottleWithClock(collect(&reported), 1, 10*time.Second, clock)
+
+	emitter.Emit(utils.WrapFloat(1))
+	emitter.Emit(utils.WrapFloat(2))
+	emitter.Reset()
+	clock.Advance(10 * time.Second)
+	expectReported(t, reported, 1)
+
+	emitter.Emit(utils.WrapFloat(3))
+	expectReported(t, reported, 1, 3)
+}
@@ -1,5 +1,9 @@
 package utils
 
+import (
+	"math"
+)
+
 // support for uint8
 
 type Equatable interface {
@@ -27,6 +31,10 @@ func (w *WrappedUint8) Unwrap() uint8 {
 	return w.val
 }
 
+func (w *WrappedUint8) Float() float64 {
+	return float64(w.val)
+}
+
 // support for bool
 
 type WrappedBool struct {
@@ -49,3 +57,135 @@ func (w *WrappedBool) Equals(other Equatable) bool {
 func (w *WrappedBool) Unwrap() bool {
 	return w.val
 }
+
+// support for float64
+
+//
+// DefaultTolerance is the tolerance of floats wrapped with WrapFloat. It is
+// small enough to treat only rounding noise as equal.
+//
+const DefaultTolerance = 1e-9
+
+type WrappedFloat struct {
+	val       float64
+	tolerance float64
+}
+
+func WrapFloat(v float64) *WrappedFloat {
+	return &WrappedFloat{v, DefaultTolerance}
+}
+
+//
+// Wrap a float that is equal to any float within the tolerance of it, so
+// that readings which differ by less than the resolution of a sensor are
+// treated as unchanged.
+//
+func WrapFloatWithTolerance(v float64, tolerance float64) *WrappedFloat {
+	return &WrappedFloat{v, math.Abs(tolerance)}
+}
+
+func (w *WrappedFloat) Equals(other Equatable) bool {
+	switch other.(type) {
+	case *WrappedFloat:
+		o := other.(*WrappedFloat)
+		return math.Abs(w.val-o.val) <= math.Max(w.tolerance, o.tolerance)
+	default:
+		return false
+	}
+}
+
+func (w *WrappedFloat) Unwrap() float64 {
+	return w.val
+}
+
+func (w *WrappedFloat) Float() float64 {
+	return w.val
+}
+
+// support for int
+
+type WrappedInt struct {
+	val int
+}
+
+func WrapInt(v int) *WrappedInt {
+	return &WrappedInt{v}
+}
+
+func (w *WrappedInt) Equals(other Equatable) bool {
+	switch other.(type) {
+	case *WrappedInt:
+		return w.val == other.(*WrappedInt).val
+	default:
+		return false
+	}
+}
+
+func (w *WrappedInt) Unwrap() int {
+	return w.val
+}
+
+func (w *WrappedInt) Float() float64 {
+	return float64(w.val)
+}
+
+// support for string
+
+type WrappedString struct {
+	val string
+}
+
+func WrapString(v string) *WrappedString {
+	return &WrappedString{v}
+}
+
+func (w *WrappedString) Equals(other Equatable) bool {
+	switch other.(type) {
+	case *WrappedString:
+		return w.val == other.(*WrappedString).val
+	default:
+		return false
+	}
+}
+
+func (w *WrappedString) Unwrap() string {
+	return w.val
+}
+
+// support for composite values
+
+//
+// A WrappedComposite is a reading made of several values, such as the
+// type and level of an alarm. Two composites are equal if they have the
+// same number of values and each value equals the corresponding value of
+// the other, so the tolerance of wrapped floats applies field by field.
+//
+type WrappedComposite struct {
+	vals []Equatable
+}
+
+func WrapComposite(v ...Equatable) *WrappedComposite {
+	return &WrappedComposite{v}
+}
+
+func (w *WrappedComposite) Equals(other Equatable) bool {
+	switch other.(type) {
+	case *WrappedComposite:
+		o := other.(*WrappedComposite)
+		if len(w.vals) != len(o.vals) {
+			return false
+		}
+		for i, val := range w.vals {
+			if !val.Equals(o.vals[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *WrappedComposite) Unwrap() []Equatable {
+	return w.vals
+}
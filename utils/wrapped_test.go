@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestWrappedValuesEqualSameValueOfSameType(t *testing.T) {
+	cases := []struct {
+		a, b  Equatable
+		equal bool
+	}{
+		{WrapUint8(3), WrapUint8(3), true},
+		{WrapUint8(3), WrapUint8(4), false},
+		{WrapBool(true), WrapBool(true), true},
+		{WrapBool(true), WrapBool(false), false},
+		{WrapInt(-7), WrapInt(-7), true},
+		{WrapInt(-7), WrapInt(7), false},
+		{WrapString("heat"), WrapString("heat"), true},
+		{WrapString("heat"), WrapString("Heat"), false},
+		{WrapFloat(21.5), WrapFloat(21.5), true},
+		{WrapFloat(21.5), WrapFloat(21.6), false},
+		{WrapInt(3), WrapUint8(3), false},
+		{WrapUint8(1), WrapBool(true), false},
+		{WrapFloat(3), WrapInt(3), false},
+	}
+	for _, c := range cases {
+		if c.a.Equals(c.b) != c.equal {
+			t.Errorf("expected %#v equals %#v to be %v", c.a, c.b, c.equal)
+		}
+	}
+}
+
+func TestWrappedFloatTolerance(t *testing.T) {
+	if !WrapFloat(0.1 + 0.2).Equals(WrapFloat(0.3)) {
+		t.Error("expected rounding noise to be within the default tolerance")
+	}
+	if !WrapFloatWithTolerance(10, 0.5).Equals(WrapFloatWithTolerance(10.5, 0.5)) {
+		t.Error("expected a difference equal to the tolerance to be equal")
+	}
+	if WrapFloatWithTolerance(10, 0.5).Equals(WrapFloatWithTolerance(10.6, 0.5)) {
+		t.Error("expected a difference greater than the tolerance not to be equal")
+	}
+	if !WrapFloatWithTolerance(10, -0.5).Equals(WrapFloat(10.4)) {
+		t.Error("expected a negative tolerance to be taken as its magnitude")
+	}
+	// the larger tolerance applies, so equality is symmetric
+	if !WrapFloat(10.4).Equals(WrapFloatWithTolerance(10, 0.5)) {
+		t.Error("expected the tolerance of either float to apply")
+	}
+}
+
+func TestWrappedCompositeEqualsFieldByField(t *testing.T) {
+	a := WrapComposite(WrapUint8(1), WrapFloatWithTolerance(20, 0.5))
+
+	if !a.Equals(WrapComposite(WrapUint8(1), WrapFloat(20.3))) {
+		t.Error("expected the tolerance of a field to apply")
+	}
+	if a.Equals(WrapComposite(WrapUint8(2), WrapFloat(20))) {
+		t.Error("expected a changed field not to be equal")
+	}
+	if a.Equals(WrapComposite(WrapUint8(1))) {
+		t.Error("expected composites with different numbers of fields not to be equal")
+	}
+	if a.Equals(WrapUint8(1)) {
+		t.Error("expected a composite not to equal a single value")
+	}
+}
+
+func TestWrappedNumbersAnswerFloats(t *testing.T) {
+	if WrapUint8(99).Float() != 99 || WrapInt(-3).Float() != -3 || WrapFloat(1.5).Float() != 1.5 {
+		t.Error("expected numeric wrappers to answer their value as a float")
+	}
+	if WrapInt(42).Unwrap() != 42 {
+		t.Error("expected the wrapped int to be answered")
+	}
+}